@@ -0,0 +1,291 @@
+/*
+ * Code generated by protogen from the go types in the game package. DO NOT EDIT.
+ */
+
+export const PROTOCOL_VERSION = 2;
+export const MIN_PROTOCOL_VERSION = 1;
+
+export const BINARY_FEATURE = "binary";
+export const BATCHING_FEATURE = "batch";
+export const COMPRESSION_FEATURE = "compress";
+
+/** in, msg: none */
+export const START_CODE = 1;
+/** in, msg: TextMsg */
+export const TEXT_CODE = 2;
+/** inout, msg: DrawMsg */
+export const DRAW_CODE = 3;
+/** out, msg: Chat */
+export const CHAT_CODE = 4;
+/** out, msg: FinishMsg */
+export const FINISH_CODE = 5;
+/** out, msg: BeginMsg */
+export const BEGIN_CODE = 6;
+/** out, msg: PlayerMsg */
+export const JOIN_CODE = 7;
+/** out, msg: PlayerMsg */
+export const LEAVE_CODE = 8;
+/** out, msg: none */
+export const TIMEOUT_CODE = 9;
+/** in, msg: none */
+export const SAVE_CODE = 10;
+/** out, msg: StateJson */
+export const STATE_CODE = 11;
+/** out, msg: ErrorMsg */
+export const ERROR_CODE = 12;
+/** in, msg: StrokeMsg */
+export const STROKE_CODE = 13;
+/** out, msg: DrawBatchMsg */
+export const DRAW_BATCH_CODE = 14;
+/** out, msg: ProtocolMsg */
+export const PROTOCOL_CODE = 15;
+/** out, msg: none */
+export const SHUTDOWN_CODE = 16;
+/** out, msg: ExpiryMsg */
+export const EXPIRY_CODE = 17;
+/** in, msg: none */
+export const EXTEND_CODE = 18;
+/** in, msg: RematchMsg */
+export const REMATCH_CODE = 19;
+/** out, msg: VoteMsg */
+export const VOTE_CODE = 20;
+/** out, msg: RestartMsg */
+export const RESTART_CODE = 21;
+/** out, msg: HostMsg */
+export const HOST_CODE = 22;
+/** in, msg: TransferMsg */
+export const TRANSFER_CODE = 23;
+/** out, msg: ChooseMsg */
+export const CHOOSE_CODE = 24;
+/** out, msg: WordsMsg */
+export const WORDS_CODE = 25;
+/** in, msg: PickMsg */
+export const PICK_CODE = 26;
+/** out, msg: WordMsg */
+export const WORD_CODE = 27;
+/** out, msg: HintMsg */
+export const HINT_CODE = 28;
+/** out, msg: CloseMsg */
+export const CLOSE_CODE = 29;
+/** in, msg: TeamMsg */
+export const TEAM_CODE = 30;
+/** out, msg: TeamsMsg */
+export const TEAMS_CODE = 31;
+/** out, msg: SpectatorsMsg */
+export const SPECTATORS_CODE = 32;
+/** out, msg: Chat */
+export const SPECTATOR_CHAT_CODE = 33;
+/** out, msg: RelayMsg */
+export const RELAY_CODE = 34;
+/** in, msg: DescribeMsg */
+export const DESCRIBE_CODE = 35;
+/** out, msg: DescriptionMsg */
+export const DESCRIPTION_CODE = 36;
+
+export interface Payload<T = any> {
+    code: number;
+    msg: T;
+    TraceID?: string;
+}
+
+export interface BeginMsg {
+    nextWord: string;
+    nextPlayerIndex: number;
+    deadline: number;
+}
+
+export interface Chat {
+    player: Player;
+    text: string;
+    guessPointsInc: number;
+    guessed: boolean;
+}
+
+export interface ChooseMsg {
+    nextPlayerIndex: number;
+    describerIndex: number;
+    deadline: number;
+}
+
+export interface Circle {
+    color: number;
+    radius: number;
+    x: number;
+    y: number;
+    connected: boolean;
+}
+
+export interface CloseMsg {
+    text: string;
+}
+
+export interface DescribeMsg {
+    text: string;
+}
+
+export interface DescriptionMsg {
+    description: string;
+}
+
+export interface ErrorMsg {
+    errorDesc: string;
+}
+
+export interface ExpiryMsg {
+    expireTime: number;
+    remainingSecs: number;
+}
+
+export interface FinishMsg {
+    beginMsg: BeginMsg | null;
+    chooseMsg: ChooseMsg | null;
+    drawScoreInc: number;
+    word: string;
+}
+
+export interface HintMsg {
+    pattern: string;
+}
+
+export interface HostMsg {
+    player: Player;
+}
+
+export interface Match {
+    players: Player[];
+    scoreBoard: { [key: string]: Score };
+}
+
+export interface PickMsg {
+    index: number;
+}
+
+export interface Player {
+    id: string;
+    name: string;
+}
+
+export interface PlayerMsg {
+    playerIndex: number;
+    player: Player;
+    queued: boolean;
+}
+
+export interface Point {
+    x: number;
+    y: number;
+}
+
+export interface ProtocolMsg {
+    version: number;
+    features: string[];
+}
+
+export interface RelayMsg {
+    nextPlayerIndex: number;
+}
+
+export interface RematchMsg {
+    settings: RoomSettings | null;
+}
+
+export interface RestartMsg {
+    settings: RoomSettings;
+    match: Match;
+    beginMsg: BeginMsg | null;
+    chooseMsg: ChooseMsg | null;
+}
+
+export interface RoomSettings {
+    playerLimit: number;
+    totalRounds: number;
+    timeLimitSecs: number;
+    customWordBank: string[];
+    isPublic: boolean;
+    coalesceMs: number;
+    idleTimeoutSecs: number;
+    maxGameSecs: number;
+    postStageSecs: number;
+    wordChoices: number;
+    choiceTimeSecs: number;
+    hintCount: number;
+    hintPenalty: number;
+    scoring: string;
+    teamCount: number;
+    spectatorChat: boolean;
+    mode: string;
+}
+
+export interface Score {
+    points: number;
+}
+
+export interface SpectatorsMsg {
+    count: number;
+}
+
+export interface StateJson {
+    currRound: number;
+    players: Player[];
+    scoreBoard: { [key: string]: Score };
+    chatLog: Chat[];
+    stage: number;
+    turn: TurnJson;
+    matches: Match[];
+    host: Player | null;
+    teams: { [key: string]: number };
+    teamScores: number[];
+    queued: string[];
+}
+
+export interface StrokeMsg {
+    color: number;
+    radius: number;
+    connected: boolean;
+    points: Point[];
+}
+
+export interface TeamMsg {
+    team: number;
+}
+
+export interface TeamsMsg {
+    teams: { [key: string]: number };
+}
+
+export interface TextMsg {
+    text: string;
+}
+
+export interface TransferMsg {
+    playerId: string;
+}
+
+export interface TurnJson {
+    currWord: string;
+    currPlayer: Player | null;
+    canvas: string;
+    deadline: number;
+    choosing: boolean;
+    drawers: Player[];
+    describer: Player | null;
+    describing: boolean;
+    description: string;
+}
+
+export interface VoteMsg {
+    player: Player;
+    votes: number;
+    needed: number;
+}
+
+export interface WordMsg {
+    word: string;
+}
+
+export interface WordsMsg {
+    words: string[];
+}
+
+export type DrawMsg = Circle;
+export type DrawBatchMsg = Circle[];
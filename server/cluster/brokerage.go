@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"guessthesketch/game"
+	"guessthesketch/pubsub"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	Replicas     = 64                 // virtual nodes per node on the ring
+	SecretHeader = "X-Cluster-Secret" // authenticates requests between nodes
+)
+
+// a brokerage spread across several server instances: each room code is owned by a single node chosen by consistent
+// hashing, rooms owned by other nodes are reached through remote brokers forwarding websocket traffic to the owner
+type ClusterBrokerage struct {
+	local     game.Brokerage // rooms owned by this node
+	ring      *Ring
+	self      string   // address of this node, as it appears in nodes
+	peers     []string // addresses of every other node
+	secret    string
+	pubsub    pubsub.PubSub // shared by every node in the cluster, nil if frames are forwarded over the websockets
+	client    *http.Client
+	directory map[string][]string // maps peer addresses to the public codes of the rooms they own
+	mu        sync.Mutex          // used to synchronize the directory
+}
+
+func NewClusterBrokerage(local game.Brokerage, self string, nodes []string, secret string, period time.Duration) *ClusterBrokerage {
+	return NewClusterBrokerageWithPubSub(local, self, nodes, secret, period, nil)
+}
+
+func NewClusterBrokerageWithPubSub(
+	local game.Brokerage, self string, nodes []string,
+	secret string, period time.Duration, ps pubsub.PubSub) *ClusterBrokerage {
+
+	peers := make([]string, 0)
+	for _, node := range nodes {
+		if node != self {
+			peers = append(peers, node)
+		}
+	}
+
+	brokerage := &ClusterBrokerage{
+		local:     local,
+		ring:      NewRing(nodes, Replicas),
+		self:      self,
+		peers:     peers,
+		secret:    secret,
+		pubsub:    ps,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		directory: make(map[string][]string),
+	}
+	if period > 0 {
+		go brokerage.startSync(period)
+	}
+	return brokerage
+}
+
+func (brokerage *ClusterBrokerage) Owns(code string) bool {
+	return brokerage.ring.Owner(code) == brokerage.self
+}
+
+func (brokerage *ClusterBrokerage) Get(code string) game.Broker {
+	owner := brokerage.ring.Owner(code)
+	if owner == brokerage.self {
+		return brokerage.local.Get(code)
+	}
+	// the owner rejects the join if the room doesn't exist, the directory may not know about new rooms yet
+	return NewRemoteBrokerWithPubSub(owner, code, brokerage.secret, brokerage.pubsub)
+}
+
+func (brokerage *ClusterBrokerage) Set(code string, b game.Broker) {
+	if !brokerage.Owns(code) {
+		log.Printf("Storing room %s on node %s that doesn't own it", code, brokerage.self)
+	}
+	brokerage.local.Set(code, b)
+}
+
+// the public codes of the rooms owned by this node, shared with the other nodes in the directory
+func (brokerage *ClusterBrokerage) LocalCodes() []string {
+	return brokerage.local.Codes(0, math.MaxInt32)
+}
+
+// public codes across the whole cluster: the local codes first, then each peer's codes in a stable order
+func (brokerage *ClusterBrokerage) Codes(offset int, limit int) []string {
+	all := brokerage.LocalCodes()
+
+	brokerage.mu.Lock()
+	for _, peer := range brokerage.peers {
+		all = append(all, brokerage.directory[peer]...)
+	}
+	brokerage.mu.Unlock()
+
+	codes := make([]string, 0)
+	for i := offset; i < offset+limit && i < len(all); i++ {
+		codes = append(codes, all[i])
+	}
+	return codes
+}
+
+func (brokerage *ClusterBrokerage) fetchCodes(peer string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, peer+"/api/cluster/rooms", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(SecretHeader, brokerage.secret)
+
+	resp, err := brokerage.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Peer %s responded with status %d", peer, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = json.Unmarshal(body, &codes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// exchanges the room directory with every peer, unreachable peers are dropped until they respond again
+func (brokerage *ClusterBrokerage) Sync() {
+	directory := make(map[string][]string)
+	for _, peer := range brokerage.peers {
+		codes, err := brokerage.fetchCodes(peer)
+		if err != nil {
+			log.Printf("Failed to sync room directory with %s: %v", peer, err)
+			continue
+		}
+		directory[peer] = codes
+	}
+
+	brokerage.mu.Lock()
+	defer brokerage.mu.Unlock()
+	brokerage.directory = directory
+}
+
+func (brokerage *ClusterBrokerage) startSync(period time.Duration) {
+	// periodically refresh the directory of rooms owned by the other nodes
+	for range time.NewTicker(period).C {
+		brokerage.Sync()
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package cluster
+
+import (
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"guessthesketch/game"
+	"guessthesketch/pubsub"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a broker for a room owned by another node, each subscriber gets its own websocket to the owner that messages are
+// forwarded to the room over, the frames of the room reach the subscriber through the pubsub shared by the nodes or
+// are forwarded back over the websocket without one
+type RemoteBroker struct {
+	owner  string // address of the node owning the room
+	code   string
+	secret string
+	pubsub pubsub.PubSub // shared by every node in the cluster, nil if the nodes don't share one
+	conns  map[chan pubsub.Message]*websocket.Conn
+	mu     sync.Mutex // used to synchronize the connections
+}
+
+func NewRemoteBroker(owner string, code string, secret string) *RemoteBroker {
+	return NewRemoteBrokerWithPubSub(owner, code, secret, nil)
+}
+
+func NewRemoteBrokerWithPubSub(owner string, code string, secret string, ps pubsub.PubSub) *RemoteBroker {
+	return &RemoteBroker{
+		owner:  owner,
+		code:   code,
+		secret: secret,
+		pubsub: ps,
+		conns:  make(map[chan pubsub.Message]*websocket.Conn),
+	}
+}
+
+// the room runs on the owner, so there is nothing to start locally
+func (broker *RemoteBroker) Start() {}
+
+func (broker *RemoteBroker) joinUrl(m game.SubscriberMsg) string {
+	query := url.Values{}
+	query.Set("code", broker.code)
+	query.Set("id", m.Player.ID.String())
+	query.Set("name", m.Player.Name)
+	query.Set("version", strconv.Itoa(m.Protocol.Version))
+	query.Set("features", strings.Join(m.Protocol.Features(), ","))
+	if m.Spectator {
+		query.Set("spectate", "true")
+	}
+	if m.Remote {
+		query.Set("subscriber", m.ID)
+	}
+
+	wsOwner := "ws" + strings.TrimPrefix(broker.owner, "http")
+	return wsOwner + "/api/cluster/join?" + query.Encode()
+}
+
+func (broker *RemoteBroker) Join(m game.SubscriberMsg) {
+	// the subscription is in place before the owner is asked to join, so the subscriber can't miss its join
+	if broker.pubsub != nil {
+		m.ID = uuid.NewString()
+		m.Remote = true
+		if err := broker.subscribe(m); err != nil {
+			log.Printf("Failed to subscribe to room %s: %v", broker.code, err)
+			broker.unsubscribe(m)
+			broker.reject(m, "Failed to reach the server hosting the room")
+			return
+		}
+	}
+
+	header := http.Header{}
+	header.Set(SecretHeader, broker.secret)
+
+	ws, resp, err := websocket.DefaultDialer.Dial(broker.joinUrl(m), header)
+	if err != nil {
+		errorDesc := "Failed to reach the server hosting the room"
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			errorDesc = "Cannot find room for provided code"
+		}
+		log.Printf("Failed to forward join for room %s to %s: %v", broker.code, broker.owner, err)
+		broker.unsubscribe(m)
+		broker.reject(m, errorDesc)
+		return
+	}
+
+	broker.mu.Lock()
+	broker.conns[m.Subscriber] = ws
+	broker.mu.Unlock()
+
+	go broker.forward(ws, m)
+}
+
+func (broker *RemoteBroker) subscribe(m game.SubscriberMsg) error {
+	for _, topic := range m.Topics(broker.code) {
+		if err := broker.pubsub.Subscribe(topic, m.Subscriber); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribes a subscriber this node subscribed, so nothing is published to its channel once it is closed
+func (broker *RemoteBroker) unsubscribe(m game.SubscriberMsg) {
+	if !m.Remote {
+		return
+	}
+	for _, topic := range m.Topics(broker.code) {
+		if err := broker.pubsub.Unsubscribe(topic, m.Subscriber); err != nil {
+			log.Printf("Failed to unsubscribe from room %s: %v", broker.code, err)
+		}
+	}
+}
+
+// mirrors a room rejecting a subscriber: sends the error then closes the channel
+func (broker *RemoteBroker) reject(m game.SubscriberMsg, errorDesc string) {
+	go func() {
+		if buf, err := game.CreateErrorResponse(errorDesc); err == nil {
+			m.Subscriber <- pubsub.Message{Data: buf}
+		}
+		close(m.Subscriber)
+	}()
+}
+
+// reads frames from the owner and passes them to the subscriber until the owner closes the connection, with a shared
+// pubsub the owner only sends the error rejecting a subscriber this way
+func (broker *RemoteBroker) forward(ws *websocket.Conn, m game.SubscriberMsg) {
+	subscriber := m.Subscriber
+	defer func() {
+		broker.mu.Lock()
+		delete(broker.conns, subscriber)
+		broker.mu.Unlock()
+
+		_ = ws.Close()
+		broker.unsubscribe(m)
+		close(subscriber)
+	}()
+	for {
+		messageType, buf, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		subscriber <- pubsub.Message{Data: buf, Binary: messageType == websocket.BinaryMessage}
+	}
+}
+
+func (broker *RemoteBroker) conn(s chan pubsub.Message) *websocket.Conn {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	return broker.conns[s]
+}
+
+func (broker *RemoteBroker) Leave(s chan pubsub.Message) {
+	ws := broker.conn(s)
+	if ws == nil {
+		return
+	}
+	// the owner unsubscribes when the connection closes, the forwarder then closes the subscriber
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+}
+
+func (broker *RemoteBroker) SendMessage(m game.SentMsg) {
+	ws := broker.conn(m.Sender)
+	if ws == nil {
+		return
+	}
+	messageType := websocket.TextMessage
+	if m.Binary {
+		messageType = websocket.BinaryMessage
+	}
+	err := ws.WriteMessage(messageType, m.Message)
+	if err != nil {
+		log.Printf("Failed to forward message to room %s: %v", broker.code, err)
+	}
+}
+
+// the owner manages the room lifecycle, a remote broker is never stored or purged locally
+func (broker *RemoteBroker) Stop(_ int) {}
+
+func (broker *RemoteBroker) IsExpired(_ time.Time) bool {
+	return false
+}
+
+func (broker *RemoteBroker) IsPublic() bool {
+	return false
+}
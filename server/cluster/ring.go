@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// consistent hash ring mapping room codes to the node that owns them, so every node agrees on the owner without
+// coordinating and only a fraction of codes move when a node is added or removed
+type Ring struct {
+	hashes []uint32          // sorted hashes of every virtual node
+	nodes  map[uint32]string // maps each virtual node hash to the node address
+}
+
+// fnv spreads similar keys such as node addresses poorly around the ring, so a cryptographic hash is used instead
+func hashKey(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// creates a ring with the given number of virtual nodes per node to spread codes evenly
+func NewRing(nodes []string, replicas int) *Ring {
+	ring := &Ring{
+		hashes: make([]uint32, 0, len(nodes)*replicas),
+		nodes:  make(map[uint32]string),
+	}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			h := hashKey(node + "#" + strconv.Itoa(i))
+			ring.hashes = append(ring.hashes, h)
+			ring.nodes[h] = node
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+	return ring
+}
+
+// finds the node owning the key: the first virtual node clockwise from the key's hash
+func (ring *Ring) Owner(key string) string {
+	if len(ring.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= h
+	})
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.nodes[ring.hashes[i]]
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing_Owner(t *testing.T) {
+	nodes := []string{"http://node1", "http://node2", "http://node3"}
+	ring := NewRing(nodes, Replicas)
+	// nodes may list each other in any order, every node must still agree on the owners
+	otherRing := NewRing([]string{nodes[2], nodes[0], nodes[1]}, Replicas)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		code := fmt.Sprintf("%08x", i)
+		owner := ring.Owner(code)
+		if owner != otherRing.Owner(code) {
+			t.Fatalf("Rings with the same nodes disagree on the owner of %s", code)
+		}
+		counts[owner]++
+	}
+
+	for _, node := range nodes {
+		if counts[node] < 500 {
+			t.Fatalf("Expected codes to be spread evenly across nodes, got %v", counts)
+		}
+	}
+}
+
+func TestRing_OwnerAfterRemove(t *testing.T) {
+	ring := NewRing([]string{"http://node1", "http://node2", "http://node3"}, Replicas)
+	smallerRing := NewRing([]string{"http://node1", "http://node2"}, Replicas)
+
+	// only the codes owned by the removed node should move
+	for i := 0; i < 1000; i++ {
+		code := fmt.Sprintf("%08x", i)
+		owner := ring.Owner(code)
+		if owner != "http://node3" && owner != smallerRing.Owner(code) {
+			t.Fatalf("Code %s moved from %s after removing an unrelated node", code, owner)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	UpdateStatsJob  = "update_stats"
+	SaveSnapshotJob = "save_snapshot"
+
+	// how often idle workers check for jobs whose backoff has elapsed
+	JobPollInterval = time.Second
+	// the longest a failed job waits before its next attempt
+	MaxJobBackoff = time.Minute
+)
+
+const jobsTable = `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		run_at INTEGER NOT NULL,
+		claimed INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		dead INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_jobs_run_at ON jobs (dead, claimed, run_at);`
+
+// runs the payload of a job in the transaction that deletes the job, so the writes of a job are committed exactly once,
+// a returned error rolls them back and schedules the job to be retried
+type JobHandler func(tx *sqlx.Tx, payload []byte) error
+
+// a job that couldn't be stored, kept in memory until a worker stores it
+type bufferedJob struct {
+	kind    string
+	payload string
+}
+
+// a queue of jobs persisted in the database, so a job survives failures and restarts until it succeeds or is
+// dead-lettered after running out of attempts
+type JobQueue struct {
+	db          *sqlx.DB
+	handlers    map[string]JobHandler
+	workers     int           // the most jobs that can run at once
+	maxAttempts int           // attempts before a job is dead-lettered
+	backoff     time.Duration // the wait after the first failed attempt, doubled for each attempt after
+	wake        chan struct{} // wakes an idle worker when a job is enqueued
+	drain       chan struct{} // closed when the queue is drained, workers exit once no jobs are ready
+	drainOnce   sync.Once
+	running     sync.WaitGroup
+	mu          sync.Mutex    // serializes claiming so a job is never claimed by two workers
+	buffered    []bufferedJob // jobs that failed to be stored, retried by the workers
+	bufMu       sync.Mutex    // used to synchronize the buffered jobs
+}
+
+func NewJobQueue(db *sqlx.DB, workers int, maxAttempts int, backoff time.Duration) (*JobQueue, error) {
+	_, err := db.Exec(jobsTable)
+	if err != nil {
+		log.Printf("Failed to create jobs table: %v", err)
+		return nil, err
+	}
+	return &JobQueue{
+		db:          db,
+		handlers:    make(map[string]JobHandler),
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		wake:        make(chan struct{}, 1),
+		drain:       make(chan struct{}),
+	}, nil
+}
+
+// registers the handler for a kind of job, every handler should be registered before the queue is started
+func (queue *JobQueue) Handle(kind string, handler JobHandler) {
+	queue.handlers[kind] = handler
+}
+
+// starts the workers, jobs claimed by a previous process that died before finishing them are released first
+func (queue *JobQueue) Start() error {
+	_, err := queue.db.Exec("UPDATE jobs SET claimed = 0 WHERE claimed = 1")
+	if err != nil {
+		log.Printf("Failed to release claimed jobs: %v", err)
+		return err
+	}
+	for i := 0; i < queue.workers; i++ {
+		queue.running.Add(1)
+		go queue.work()
+	}
+	return nil
+}
+
+// stores a job to be run by a worker, the payload is serialized as json. a job the database can't store right now
+// is kept in memory until a worker stores it, so only a payload that can't be serialized is an error
+func (queue *JobQueue) Enqueue(kind string, payload any) error {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to serialize %s job: %v", kind, err)
+		return err
+	}
+
+	err = queue.insert(bufferedJob{kind: kind, payload: string(buf)})
+	if err != nil {
+		log.Printf("Failed to enqueue %s job, keeping it in memory until it can be stored: %v", kind, err)
+		queue.bufMu.Lock()
+		queue.buffered = append(queue.buffered, bufferedJob{kind: kind, payload: string(buf)})
+		queue.bufMu.Unlock()
+	}
+
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+		// a wake up is already pending
+	}
+	return nil
+}
+
+func (queue *JobQueue) insert(job bufferedJob) error {
+	query := "INSERT INTO jobs (kind, payload, run_at) VALUES ($1, $2, $3)"
+	_, err := queue.db.Exec(query, job.kind, job.payload, time.Now().UnixMilli())
+	return err
+}
+
+// stores the jobs kept in memory, the jobs that still can't be stored are kept for the next attempt
+func (queue *JobQueue) storeBuffered() {
+	queue.bufMu.Lock()
+	defer queue.bufMu.Unlock()
+
+	remaining := queue.buffered[:0]
+	for _, job := range queue.buffered {
+		if err := queue.insert(job); err != nil {
+			log.Printf("Failed to store buffered %s job: %v", job.kind, err)
+			remaining = append(remaining, job)
+		}
+	}
+	queue.buffered = remaining
+}
+
+// runs the jobs that are ready then stops the workers, or gives up once the context is done. jobs waiting on
+// their backoff stay in the database to be run when the queue is next started
+func (queue *JobQueue) Drain(ctx context.Context) error {
+	queue.drainOnce.Do(func() {
+		close(queue.drain)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		queue.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		queue.bufMu.Lock()
+		defer queue.bufMu.Unlock()
+		if len(queue.buffered) > 0 {
+			return fmt.Errorf("Drained the queue with %d jobs that couldn't be stored", len(queue.buffered))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (queue *JobQueue) work() {
+	defer queue.running.Done()
+	for {
+		queue.storeBuffered()
+
+		job, err := queue.claim(time.Now())
+		if err != nil {
+			log.Printf("Failed to claim a job: %v", err)
+		}
+		if job != nil {
+			queue.run(*job)
+			continue
+		}
+
+		select {
+		case <-queue.drain:
+			return
+		default:
+		}
+
+		select {
+		case <-queue.wake:
+		case <-queue.drain:
+		case <-time.After(JobPollInterval):
+		}
+	}
+}
+
+// claims the oldest job that is ready to run, or returns nil if there isn't one
+func (queue *JobQueue) claim(now time.Time) (*Job, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	var job Job
+	query := `
+		SELECT * FROM jobs
+		WHERE dead = 0 AND claimed = 0 AND run_at <= $1
+		ORDER BY run_at, id LIMIT 1`
+
+	err := queue.db.Get(&job, query, now.UnixMilli())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = queue.db.Exec("UPDATE jobs SET claimed = 1 WHERE id = $1", job.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (queue *JobQueue) run(job Job) {
+	var err error
+	handler, ok := queue.handlers[job.Kind]
+	if ok {
+		err = queue.runAndDelete(job, handler)
+	} else {
+		err = fmt.Errorf("No handler for job kind %s", job.Kind)
+	}
+	if err == nil {
+		return
+	}
+
+	attempts := job.Attempts + 1
+	if !ok || attempts >= queue.maxAttempts {
+		log.Printf("Dead-lettering %s job %d after %d attempts: %v", job.Kind, job.ID, attempts, err)
+		query := "UPDATE jobs SET attempts = $1, last_error = $2, dead = 1, claimed = 0 WHERE id = $3"
+		_, err = queue.db.Exec(query, attempts, err.Error(), job.ID)
+		if err != nil {
+			log.Printf("Failed to dead-letter job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	delay := queue.retryDelay(attempts)
+	log.Printf("Retrying %s job %d in %v after attempt %d failed: %v", job.Kind, job.ID, delay, attempts, err)
+	runAt := time.Now().Add(delay).UnixMilli()
+	query := "UPDATE jobs SET attempts = $1, last_error = $2, run_at = $3, claimed = 0 WHERE id = $4"
+	_, err = queue.db.Exec(query, attempts, err.Error(), runAt, job.ID)
+	if err != nil {
+		log.Printf("Failed to reschedule job %d: %v", job.ID, err)
+	}
+}
+
+// deletes the job in the transaction the handler writes in, so a job is never run again once its writes are committed
+func (queue *JobQueue) runAndDelete(job Job, handler JobHandler) error {
+	tx, err := queue.db.Beginx()
+	if err != nil {
+		return err
+	}
+	err = handler(tx, []byte(job.Payload))
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM jobs WHERE id = $1", job.ID)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// exponential backoff from the base delay, capped at the max backoff
+func (queue *JobQueue) retryDelay(attempts int) time.Duration {
+	delay := queue.backoff
+	for i := 1; i < attempts && delay < MaxJobBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxJobBackoff {
+		delay = MaxJobBackoff
+	}
+	return delay
+}
+
+// the jobs that ran out of attempts, kept so they can be inspected and retried by hand
+func GetDeadJobs(db *sqlx.DB) ([]Job, error) {
+	var jobs []Job
+	err := db.Select(&jobs, "SELECT * FROM jobs WHERE dead = 1 ORDER BY id")
+	if err != nil {
+		log.Printf("Failed to get dead jobs: %v", err)
+		return nil, errors.New("Failed to get dead jobs")
+	}
+	return jobs, nil
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"guessthesketch/game"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func CreateTestJobQueue(t *testing.T, workers int, maxAttempts int) (*sqlx.DB, *JobQueue) {
+	// workers use their own connections, so the queue needs a database shared between connections
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "jobs.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("Failed to open db %v", err)
+	}
+	CreateSchema(db)
+
+	queue, err := NewJobQueue(db, workers, maxAttempts, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create job queue %v", err)
+	}
+	return db, queue
+}
+
+func waitForJobs(t *testing.T, db *sqlx.DB, query string, exp int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var count int
+		if err := db.Get(&count, query); err == nil && count == exp {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d jobs for query %s", exp, query)
+}
+
+func TestJobQueue_RetryThenSucceed(t *testing.T) {
+	db, queue := CreateTestJobQueue(t, 1, 5)
+	defer db.Close()
+
+	// the first attempts fail like a locked database would, then the job goes through
+	var attempts atomic.Int32
+	queue.Handle("test", func(_ *sqlx.Tx, _ []byte) error {
+		if attempts.Add(1) < 3 {
+			return errors.New("database is locked")
+		}
+		return nil
+	})
+	if err := queue.Start(); err != nil {
+		t.Fatalf("Failed to start job queue %v", err)
+	}
+	if err := queue.Enqueue("test", "payload"); err != nil {
+		t.Fatalf("Failed to enqueue job %v", err)
+	}
+
+	waitForJobs(t, db, "SELECT COUNT(*) FROM jobs", 0)
+	if attempts.Load() != 3 {
+		t.Fatalf("Expected the job to run 3 times, ran %d times", attempts.Load())
+	}
+	_ = queue.Drain(context.Background())
+}
+
+func TestJobQueue_DeadLetter(t *testing.T) {
+	db, queue := CreateTestJobQueue(t, 1, 3)
+	defer db.Close()
+
+	queue.Handle("test", func(_ *sqlx.Tx, _ []byte) error {
+		return errors.New("constraint failed")
+	})
+	if err := queue.Start(); err != nil {
+		t.Fatalf("Failed to start job queue %v", err)
+	}
+	if err := queue.Enqueue("test", "payload"); err != nil {
+		t.Fatalf("Failed to enqueue job %v", err)
+	}
+
+	waitForJobs(t, db, "SELECT COUNT(*) FROM jobs WHERE dead = 1", 1)
+	_ = queue.Drain(context.Background())
+
+	jobs, err := GetDeadJobs(db)
+	if err != nil {
+		t.Fatalf("Failed to get dead jobs %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Attempts != 3 || jobs[0].LastError != "constraint failed" || jobs[0].Payload != `"payload"` {
+		t.Fatalf("Expected a dead job after 3 attempts, got %v", jobs)
+	}
+}
+
+func TestJobQueue_BoundedWorkers(t *testing.T) {
+	db, queue := CreateTestJobQueue(t, 2, 1)
+	defer db.Close()
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	queue.Handle("test", func(_ *sqlx.Tx, _ []byte) error {
+		defer wg.Done()
+		n := running.Add(1)
+		for {
+			prev := maxRunning.Load()
+			if n <= prev || maxRunning.CompareAndSwap(prev, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+
+	count := 6
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		if err := queue.Enqueue("test", i); err != nil {
+			t.Fatalf("Failed to enqueue job %v", err)
+		}
+	}
+	if err := queue.Start(); err != nil {
+		t.Fatalf("Failed to start job queue %v", err)
+	}
+	wg.Wait()
+	_ = queue.Drain(context.Background())
+
+	if maxRunning.Load() != 2 {
+		t.Fatalf("Expected at most 2 jobs to run at once, got %d", maxRunning.Load())
+	}
+}
+
+func TestJobQueue_DrainRunsReadyJobs(t *testing.T) {
+	db, queue := CreateTestJobQueue(t, 1, 1)
+	defer db.Close()
+
+	playerID := uuid.New()
+	err := InsertPlayer(db, Player{ID: playerID.String(), Username: "Player"})
+	if err != nil {
+		t.Fatalf("Failed to insert player %v", err)
+	}
+
+	queue.Handle(UpdateStatsJob, func(tx *sqlx.Tx, _ []byte) error {
+		return UpdateStatsTx(tx, []game.GameResult{{PlayerID: playerID.String(), Points: 5, Win: true}})
+	})
+	if err := queue.Enqueue(UpdateStatsJob, nil); err != nil {
+		t.Fatalf("Failed to enqueue job %v", err)
+	}
+	if err := queue.Start(); err != nil {
+		t.Fatalf("Failed to start job queue %v", err)
+	}
+	if err := queue.Drain(context.Background()); err != nil {
+		t.Fatalf("Failed to drain job queue %v", err)
+	}
+
+	var player Player
+	if err := GetPlayer(db, &player, "Player"); err != nil || player.Points != 5 || player.Wins != 1 {
+		t.Fatalf("Expected the stats to be updated before the queue drained, got %v", player)
+	}
+}
+
+func TestJobQueue_BufferFailedEnqueue(t *testing.T) {
+	db, queue := CreateTestJobQueue(t, 1, 1)
+	defer db.Close()
+
+	var runs atomic.Int32
+	queue.Handle("test", func(_ *sqlx.Tx, _ []byte) error {
+		runs.Add(1)
+		return nil
+	})
+
+	// the job can't be stored while the table is missing, so it is kept in memory instead of being lost
+	if _, err := db.Exec("ALTER TABLE jobs RENAME TO jobs_moved"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := queue.Enqueue("test", "payload"); err != nil {
+		t.Fatalf("Expected a job that can't be stored to be buffered, got %v", err)
+	}
+	if _, err := db.Exec("ALTER TABLE jobs_moved RENAME TO jobs"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := queue.Start(); err != nil {
+		t.Fatalf("Failed to start job queue %v", err)
+	}
+	if err := queue.Drain(context.Background()); err != nil {
+		t.Fatalf("Failed to drain job queue %v", err)
+	}
+	if runs.Load() != 1 {
+		t.Fatalf("Expected the buffered job to run once, ran %d times", runs.Load())
+	}
+}
+
+func TestJobQueue_FailedDeleteRollsBack(t *testing.T) {
+	db, queue := CreateTestJobQueue(t, 1, 1)
+	defer db.Close()
+
+	playerID := uuid.New()
+	err := InsertPlayer(db, Player{ID: playerID.String(), Username: "Player"})
+	if err != nil {
+		t.Fatalf("Failed to insert player %v", err)
+	}
+
+	// the job can't be deleted, so the stats it updated must not be kept or they would be counted again on a retry
+	trigger := `
+		CREATE TRIGGER keep_jobs BEFORE DELETE ON jobs
+		BEGIN SELECT RAISE(ABORT, 'database is locked'); END;`
+	if _, err = db.Exec(trigger); err != nil {
+		t.Fatalf("%v", err)
+	}
+	queue.Handle(UpdateStatsJob, func(tx *sqlx.Tx, _ []byte) error {
+		return UpdateStatsTx(tx, []game.GameResult{{PlayerID: playerID.String(), Points: 5, Win: true}})
+	})
+	if err = queue.Enqueue(UpdateStatsJob, nil); err != nil {
+		t.Fatalf("Failed to enqueue job %v", err)
+	}
+	if err = queue.Start(); err != nil {
+		t.Fatalf("Failed to start job queue %v", err)
+	}
+	waitForJobs(t, db, "SELECT COUNT(*) FROM jobs WHERE dead = 1", 1)
+	_ = queue.Drain(context.Background())
+
+	var player Player
+	if err = GetPlayer(db, &player, "Player"); err != nil || player.Points != 0 || player.Wins != 0 {
+		t.Fatalf("Expected the stats of a job that wasn't deleted to be rolled back, got %v", player)
+	}
+}
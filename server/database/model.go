@@ -19,3 +19,14 @@ type Drawing struct {
 	SavedBy   string `db:"saved_by"`
 	Signature string `db:"signature"`
 }
+
+type Job struct {
+	ID        int64  `db:"id"`
+	Kind      string `db:"kind"`
+	Payload   string `db:"payload"`
+	Attempts  int    `db:"attempts"`
+	RunAt     int64  `db:"run_at"` // unix epoch in milliseconds
+	Claimed   bool   `db:"claimed"`
+	LastError string `db:"last_error"`
+	Dead      bool   `db:"dead"`
+}
@@ -21,6 +21,7 @@ func CreateSchema(db *sqlx.DB) {
 	query := `
 		DROP TABLE IF EXISTS "players";
 		DROP TABLE IF EXISTS "drawings";
+		DROP TABLE IF EXISTS "jobs";
 
 		CREATE TABLE players (
 			id TEXT PRIMARY KEY,
@@ -44,7 +45,7 @@ func CreateSchema(db *sqlx.DB) {
 		CREATE INDEX idx_players_words_guessed ON players (words_guessed);
 		CREATE INDEX idx_players_drawings_guessed ON players (drawings_guessed);`
 
-	_ = db.MustExec(query)
+	_ = db.MustExec(query + jobsTable)
 }
 
 func InsertPlayer(db *sqlx.DB, player Player) error {
@@ -119,6 +120,27 @@ func params(queryIdx int, parameterCount int) []interface{} {
 }
 
 func UpdateStats(db *sqlx.DB, results []game.GameResult) error {
+	// the updates are applied in a transaction so a failed update can be retried without counting any result twice
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Printf("Failed to update stats: %v", err)
+		return err
+	}
+	err = UpdateStatsTx(tx, results)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Failed to update stats: %v", err)
+		return err
+	}
+	return nil
+}
+
+// updates the stats as part of the transaction, the results are only counted once it is committed
+func UpdateStatsTx(tx *sqlx.Tx, results []game.GameResult) error {
 	var qb strings.Builder
 	var args []interface{}
 
@@ -141,7 +163,7 @@ func UpdateStats(db *sqlx.DB, results []game.GameResult) error {
 		args = append(args, r.Points, winInc, r.WordsGuessed, r.DrawingsGuessed, r.PlayerID)
 	}
 
-	_, err := db.Exec(qb.String(), args...)
+	_, err := tx.Exec(qb.String(), args...)
 	if err != nil {
 		log.Printf("Failed to update stats: %v", err)
 		return err
@@ -149,7 +171,7 @@ func UpdateStats(db *sqlx.DB, results []game.GameResult) error {
 	return nil
 }
 
-func SaveSnapshot(db *sqlx.DB, snap game.Snapshot) error {
+func SaveSnapshot(db sqlx.Execer, snap game.Snapshot) error {
 	drawing := Drawing{
 		ID:        uuid.New().String(),
 		CreatedBy: snap.CreatedBy.ID.String(),
@@ -159,7 +181,7 @@ func SaveSnapshot(db *sqlx.DB, snap game.Snapshot) error {
 	return InsertDrawing(db, drawing)
 }
 
-func InsertDrawing(db *sqlx.DB, drawing Drawing) error {
+func InsertDrawing(db sqlx.Execer, drawing Drawing) error {
 	query := `
 		INSERT INTO drawings (created_by, saved_by, signature) 
 		VALUES ($1, $2, $3)`
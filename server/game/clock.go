@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"sync"
+	"time"
+)
+
+// the source of time for a room, rooms in tests use a fake clock to fast-forward through turns
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer  // fires once after the duration
+	NewTicker(d time.Duration) Timer // fires every time the duration passes
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type RealClock struct{}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (clock RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+func (clock RealClock) NewTicker(d time.Duration) Timer {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+func (timer realTimer) C() <-chan time.Time {
+	return timer.timer.C
+}
+
+func (timer realTimer) Stop() {
+	timer.timer.Stop()
+}
+
+func (ticker realTicker) C() <-chan time.Time {
+	return ticker.ticker.C
+}
+
+func (ticker realTicker) Stop() {
+	ticker.ticker.Stop()
+}
+
+// a clock that only moves when advanced, timers fire as the time they are due at is passed
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	mu     sync.Mutex
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	at     time.Time     // when the timer fires next
+	period time.Duration // zero for timers that fire once
+	c      chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) NewTimer(d time.Duration) Timer {
+	return clock.addTimer(d, 0)
+}
+
+func (clock *FakeClock) NewTicker(d time.Duration) Timer {
+	return clock.addTimer(d, d)
+}
+
+func (clock *FakeClock) addTimer(d time.Duration, period time.Duration) *fakeTimer {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	// buffered like the channels of the time package, a tick the receiver isn't ready for is dropped
+	timer := &fakeTimer{clock: clock, at: clock.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// moves the clock forward, firing every timer due by the new time
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.now = clock.now.Add(d)
+
+	timers := clock.timers[:0]
+	for _, timer := range clock.timers {
+		if timer.at.After(clock.now) {
+			timers = append(timers, timer)
+			continue
+		}
+		select {
+		case timer.c <- clock.now:
+		default:
+		}
+		if timer.period > 0 {
+			for !timer.at.After(clock.now) {
+				timer.at = timer.at.Add(timer.period)
+			}
+			timers = append(timers, timer)
+		}
+	}
+	clock.timers = timers
+}
+
+func (timer *fakeTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *fakeTimer) Stop() {
+	clock := timer.clock
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	for i, t := range clock.timers {
+		if t == timer {
+			clock.timers = append(clock.timers[:i], clock.timers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"guessthesketch/pubsub"
+	"io"
+)
+
+const (
+	// the packed size of a single circle on the wire: color, radius, x, y and connected
+	CircleSize = 7
+
+	// version 1 is the original json protocol, version 2 adds negotiable features
+	MinProtocolVersion = 1
+	ProtocolVersion    = 2
+
+	BinaryFeature      = "binary"
+	BatchingFeature    = "batch"
+	CompressionFeature = "compress"
+)
+
+var ErrBinaryFrame = errors.New("Malformed binary frame")
+
+// wire features a subscriber negotiated when joining a room
+type Protocol struct {
+	Version     int
+	Binary      bool // the subscriber accepts packed binary frames for draw and state messages
+	Batching    bool // the subscriber accepts batches of circles instead of one draw message per circle
+	Compression bool // the subscriber accepts the compressed canvas encoding in state messages
+}
+
+// sent to a client after the handshake to tell it which of its announced features were accepted
+type ProtocolMsg struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+}
+
+// negotiates the protocol for a client announcing its version and supported features, unknown features are ignored
+func NegotiateProtocol(version int, features []string) (Protocol, error) {
+	if version < MinProtocolVersion || version > ProtocolVersion {
+		return Protocol{}, fmt.Errorf("Unsupported protocol version %d, server supports versions %d to %d",
+			version, MinProtocolVersion, ProtocolVersion)
+	}
+
+	protocol := Protocol{Version: version}
+	if version < 2 {
+		// features were introduced in version 2, older clients only understand json
+		return protocol, nil
+	}
+	for _, feature := range features {
+		switch feature {
+		case BinaryFeature:
+			protocol.Binary = true
+		case BatchingFeature:
+			protocol.Batching = true
+		case CompressionFeature:
+			protocol.Compression = true
+		}
+	}
+	return protocol, nil
+}
+
+func (protocol Protocol) Features() []string {
+	features := make([]string, 0)
+	if protocol.Binary {
+		features = append(features, BinaryFeature)
+	}
+	if protocol.Batching {
+		features = append(features, BatchingFeature)
+	}
+	if protocol.Compression {
+		features = append(features, CompressionFeature)
+	}
+	return features
+}
+
+func CreateProtocolResponse(protocol Protocol) ([]byte, error) {
+	msg := ProtocolMsg{Version: protocol.Version, Features: protocol.Features()}
+	frame, err := createResponse(ProtocolCode, msg)
+	return frame.Text, err
+}
+
+// a message encoded for each wire format, so every subscriber can receive the one it negotiated
+type Frame struct {
+	Text   []byte // json encoding, understood by every client
+	Binary []byte // packed encoding, nil if the message has no binary layout
+	// frames sent instead to players who receive their own version of the message, such as the drawer who is sent
+	// the word every other player receives masked
+	Views map[uuid.UUID]Frame
+}
+
+func (frame Frame) IsEmpty() bool {
+	return frame.Text == nil && frame.Binary == nil
+}
+
+// picks the encoding of the frame to send to a subscriber with the given protocol, the message carries which one
+// was picked so it is sent to the client in a frame of the same type
+func (frame Frame) Encode(protocol Protocol) pubsub.Message {
+	if protocol.Binary && frame.Binary != nil {
+		return pubsub.Message{Data: frame.Binary, Binary: true}
+	}
+	return pubsub.Message{Data: frame.Text}
+}
+
+func packCircles(circles []Circle) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(circles) * CircleSize)
+	err := binary.Write(&buf, binary.LittleEndian, circles)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unpackCircles(buf []byte) ([]Circle, error) {
+	if len(buf)%CircleSize != 0 {
+		return nil, ErrBinaryFrame
+	}
+	circles := make([]Circle, len(buf)/CircleSize)
+	err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, circles)
+	if err != nil {
+		return nil, ErrBinaryFrame
+	}
+	return circles, nil
+}
+
+// compressed canvas layout: each circle is [color][radius][connected][x delta varint][y delta varint], with the
+// deltas taken from the previous circle, then the whole canvas is deflated (raw deflate, no zlib header)
+func compressCircles(circles []Circle) ([]byte, error) {
+	delta := make([]byte, 0, len(circles)*CircleSize)
+	var prevX, prevY int64
+	for _, circle := range circles {
+		connected := byte(0)
+		if circle.Connected {
+			connected = 1
+		}
+		delta = append(delta, circle.Color, circle.Radius, connected)
+		delta = binary.AppendVarint(delta, int64(circle.X)-prevX)
+		delta = binary.AppendVarint(delta, int64(circle.Y)-prevY)
+		prevX, prevY = int64(circle.X), int64(circle.Y)
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(delta); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressCircles(buf []byte) ([]Circle, error) {
+	delta, err := io.ReadAll(flate.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		return nil, ErrBinaryFrame
+	}
+
+	circles := make([]Circle, 0)
+	var prevX, prevY int64
+	for i := 0; i < len(delta); {
+		if len(delta)-i < 3 {
+			return nil, ErrBinaryFrame
+		}
+		circle := Circle{Color: delta[i], Radius: delta[i+1], Connected: delta[i+2] != 0}
+		i += 3
+
+		dx, n := binary.Varint(delta[i:])
+		if n <= 0 {
+			return nil, ErrBinaryFrame
+		}
+		i += n
+		dy, n := binary.Varint(delta[i:])
+		if n <= 0 {
+			return nil, ErrBinaryFrame
+		}
+		i += n
+
+		prevX, prevY = prevX+dx, prevY+dy
+		circle.X, circle.Y = uint16(prevX), uint16(prevY)
+		circles = append(circles, circle)
+	}
+	return circles, nil
+}
+
+// binary layout for a draw message: [code][circle]
+func createBinaryDraw(circle Circle) ([]byte, error) {
+	body, err := packCircles([]Circle{circle})
+	if err != nil {
+		return nil, ErrMarshal
+	}
+	return append([]byte{DrawCode}, body...), nil
+}
+
+// binary layout for a batch of draws: [code][packed circles]
+func createBinaryBatch(circles []Circle) ([]byte, error) {
+	body, err := packCircles(circles)
+	if err != nil {
+		return nil, ErrMarshal
+	}
+	return append([]byte{DrawBatchCode}, body...), nil
+}
+
+// binary layout for a stroke message: [color][radius][connected][x uint16, y uint16]...
+func unpackStroke(body []byte) (StrokeMsg, error) {
+	if len(body) < 3 || (len(body)-3)%4 != 0 {
+		return StrokeMsg{}, ErrBinaryFrame
+	}
+	stroke := StrokeMsg{
+		Color:     body[0],
+		Radius:    body[1],
+		Connected: body[2] != 0,
+		Points:    make([]Point, (len(body)-3)/4),
+	}
+	err := binary.Read(bytes.NewReader(body[3:]), binary.LittleEndian, stroke.Points)
+	if err != nil {
+		return StrokeMsg{}, ErrBinaryFrame
+	}
+	return stroke, nil
+}
+
+// binary layout for a state message: [code][json length uint32][json state without canvas][packed canvas]
+func createBinaryState(stateJson []byte, canvas []byte) []byte {
+	buf := make([]byte, 0, 5+len(stateJson)+len(canvas))
+	buf = append(buf, StateCode)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(stateJson)))
+	buf = append(buf, stateJson...)
+	return append(buf, canvas...)
+}
+
+// splits a binary frame into its message code and body
+func decodeBinaryFrame(buf []byte) (int, []byte, error) {
+	if len(buf) < 1 {
+		return 0, nil, ErrBinaryFrame
+	}
+	return int(buf[0]), buf[1:], nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"strings"
+	"unicode"
+)
+
+// letters with accents folded to the letter without them, so guessers don't need to type accents
+var accentFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u",
+	'ý': "y", 'ÿ': "y",
+	'ś': "s", 'š': "s", 'ß': "ss",
+	'ź': "z", 'ż': "z", 'ž': "z",
+	'ł': "l", 'ř': "r", 'ť': "t", 'ď': "d",
+	'æ': "ae", 'œ': "oe",
+}
+
+// splits text into words compared by guesses: lowercase, without accents or punctuation and singular
+func normalizeWords(text string) []string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if fold, ok := accentFolds[r]; ok {
+			sb.WriteString(fold)
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		} else if unicode.IsSpace(r) || r == '-' || r == '_' {
+			// hyphenated words are guessed like separate words
+			sb.WriteRune(' ')
+		}
+		// other punctuation is dropped, so "don't" matches "dont"
+	}
+
+	words := strings.Fields(sb.String())
+	for i, word := range words {
+		words[i] = singular(word)
+	}
+	return words
+}
+
+// strips the common english plural endings, both the word and the guess are stripped so a wrong guess at the
+// singular form of an irregular word doesn't matter
+func singular(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 3 && (strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") ||
+		strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "zes") || strings.HasSuffix(word, "sses")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// finds the words of the answer in the guess, returning the smallest edit distance between the answer and any run of
+// words in the guess as long as the answer. a distance of 0 means the guess contains the answer
+func guessDistance(guess string, answer string) int {
+	guessWords := normalizeWords(guess)
+	answerWords := normalizeWords(answer)
+	if len(answerWords) == 0 {
+		return -1
+	}
+	target := strings.Join(answerWords, " ")
+
+	best := -1
+	for i := 0; i+len(answerWords) <= len(guessWords); i++ {
+		run := strings.Join(guessWords[i:i+len(answerWords)], " ")
+		distance := editDistance(run, target)
+		if best < 0 || distance < best {
+			best = distance
+		}
+	}
+	return best
+}
+
+// the most edits a guess can be from the answer to be close, longer answers allow for more typos
+func closeDistance(answer string) int {
+	if len([]rune(answer)) <= 5 {
+		return 1
+	}
+	return 2
+}
+
+// the levenshtein distance between the strings
+func editDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
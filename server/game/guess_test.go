@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestGuessDistance(t *testing.T) {
+	type TestGuess struct {
+		guess       string
+		answer      string
+		expDistance int
+	}
+	tests := []TestGuess{
+		{guess: "is it word1", answer: "Word1", expDistance: 0},
+		{guess: "the QUICK brown fox", answer: "quick", expDistance: 0},
+		{guess: "Café!", answer: "cafe", expDistance: 0},
+		{guess: "cafe", answer: "café", expDistance: 0},
+		{guess: "dogs", answer: "dog", expDistance: 0},
+		{guess: "cherries", answer: "cherry", expDistance: 0},
+		{guess: "boxes", answer: "box", expDistance: 0},
+		{guess: "glass", answer: "glass", expDistance: 0},
+		{guess: "i love ice-cream", answer: "ice cream", expDistance: 0},
+		{guess: "ice", answer: "ice cream", expDistance: -1},
+		{guess: "ice crem", answer: "ice cream", expDistance: 1},
+		{guess: "quik", answer: "quick", expDistance: 1},
+		{guess: "elefant", answer: "elephant", expDistance: 2},
+		{guess: "cat", answer: "dog", expDistance: 3},
+		{guess: "", answer: "dog", expDistance: -1},
+	}
+	for i, test := range tests {
+		if distance := guessDistance(test.guess, test.answer); distance != test.expDistance {
+			t.Fatalf("Expected distance %d for guess %d %q, got %d", test.expDistance, i, test.guess, distance)
+		}
+	}
+}
+
+func TestState_IsCloseGuess(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+	drawer := Player{ID: uuid.New()}
+	guesser := Player{ID: uuid.New()}
+	state.players = []Player{drawer, guesser}
+	state.StartGame()
+	state.turn.currWord = "elephant"
+	state.turn.currPlayerIndex = 0
+
+	type TestClose struct {
+		player Player
+		guess  string
+		expect bool
+	}
+	tests := []TestClose{
+		{player: guesser, guess: "elefant", expect: true},
+		{player: guesser, guess: "elephants", expect: false},
+		{player: guesser, guess: "giraffe", expect: false},
+		{player: drawer, guess: "elefant", expect: false},
+		{player: guesser, guess: "Elefant!", expect: true},
+		{player: guesser, guess: "is it an elefant", expect: false},
+	}
+	for i, test := range tests {
+		if state.IsCloseGuess(test.player, test.guess) != test.expect {
+			t.Fatalf("Expected close guess %d %q to be %t", i, test.guess, test.expect)
+		}
+	}
+
+	// ordinary chat containing short words near the answer isn't a close guess
+	state.turn.currWord = "hat"
+	for _, guess := range []string{"that was fast", "hot", "and what is that"} {
+		if state.IsCloseGuess(guesser, guess) != (guess == "hot") {
+			t.Fatalf("Expected only the whole message to be compared with the word, got %q", guess)
+		}
+	}
+}
@@ -8,22 +8,49 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"log"
+	"math/rand"
+	"time"
 )
 
 const (
-	StartCode   = 1
-	TextCode    = 2
-	DrawCode    = 3
-	ChatCode    = 4
-	FinishCode  = 5
-	BeginCode   = 6
-	JoinCode    = 7
-	LeaveCode   = 8
-	TimeoutCode = 9
-	SaveCode    = 10
-	StateCode   = 11
-	ErrorCode   = 12
+	StartCode         = 1
+	TextCode          = 2
+	DrawCode          = 3
+	ChatCode          = 4
+	FinishCode        = 5
+	BeginCode         = 6
+	JoinCode          = 7
+	LeaveCode         = 8
+	TimeoutCode       = 9
+	SaveCode          = 10
+	StateCode         = 11
+	ErrorCode         = 12
+	StrokeCode        = 13
+	DrawBatchCode     = 14
+	ProtocolCode      = 15
+	ShutdownCode      = 16
+	ExpiryCode        = 17
+	ExtendCode        = 18
+	RematchCode       = 19
+	VoteCode          = 20
+	RestartCode       = 21
+	HostCode          = 22
+	TransferCode      = 23
+	ChooseCode        = 24
+	WordsCode         = 25
+	PickCode          = 26
+	WordCode          = 27
+	HintCode          = 28
+	CloseCode         = 29
+	TeamCode          = 30
+	TeamsCode         = 31
+	SpectatorsCode    = 32
+	SpectatorChatCode = 33
+	RelayCode         = 34
+	DescribeCode      = 35
+	DescriptionCode   = 36
 
 	MinChatLen = 5
 	MaxChatLen = 50
@@ -31,6 +58,8 @@ const (
 	MaxY       = 1000
 	MaxRadius  = 8
 	MaxColor   = 8
+
+	MaxStrokePoints = 256
 )
 
 var ErrUnMarshal = errors.New("Failed to unmarshal input data")
@@ -48,12 +77,13 @@ type OutputPayload[T any] struct {
 	TraceID string
 }
 
-func (room *Room) HandleMessage(message []byte, player Player) ([]byte, error) {
+// handles a message sent in a text frame, which is always json
+func (room *Room) HandleMessage(message []byte, player Player) (Frame, error) {
 	// deserialize payload message from json
 	var payload InputPayload[json.RawMessage]
 	err := json.Unmarshal(message, &payload)
 	if err != nil {
-		return nil, err
+		return Frame{}, err
 	}
 
 	switch payload.Code {
@@ -63,113 +93,682 @@ func (room *Room) HandleMessage(message []byte, player Player) ([]byte, error) {
 		var inputMsg TextMsg
 		err = json.Unmarshal(payload.Msg, &inputMsg)
 		if err != nil {
-			return nil, ErrUnMarshal
+			return Frame{}, ErrUnMarshal
 		}
 		return room.handleTextMessage(inputMsg, player, payload.TraceID)
 	case DrawCode:
 		var inputMsg DrawMsg
 		err = json.Unmarshal(payload.Msg, &inputMsg)
 		if err != nil {
-			return nil, ErrUnMarshal
+			return Frame{}, ErrUnMarshal
 		}
 		return room.handleDrawMessage(inputMsg, player, payload.TraceID)
+	case StrokeCode:
+		var inputMsg StrokeMsg
+		err = json.Unmarshal(payload.Msg, &inputMsg)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleStrokeMessage(inputMsg, player, payload.TraceID)
+	case ExtendCode:
+		return room.handleExtendMessage(player, payload.TraceID)
+	case RematchCode:
+		var inputMsg RematchMsg
+		err = json.Unmarshal(payload.Msg, &inputMsg)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleRematchMessage(inputMsg, player, payload.TraceID)
+	case TransferCode:
+		var inputMsg TransferMsg
+		err = json.Unmarshal(payload.Msg, &inputMsg)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleTransferMessage(inputMsg, player, payload.TraceID)
+	case TeamCode:
+		var inputMsg TeamMsg
+		err = json.Unmarshal(payload.Msg, &inputMsg)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleTeamMessage(inputMsg, player, payload.TraceID)
+	case PickCode:
+		var inputMsg PickMsg
+		err = json.Unmarshal(payload.Msg, &inputMsg)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handlePickMessage(inputMsg, player, payload.TraceID)
+	case DescribeCode:
+		var inputMsg DescribeMsg
+		err = json.Unmarshal(payload.Msg, &inputMsg)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleDescribeMessage(inputMsg, player, payload.TraceID)
 	case SaveCode:
 		capture := room.state.Capture(player)
 		room.handler.DoCapture(capture)
-		return nil, nil
+		return Frame{}, nil
 	default:
 		log.Println("Cannot handle unknown message type")
-		return nil, errors.New("No matching message types for message")
+		return Frame{}, errors.New("No matching message types for message")
+	}
+}
+
+// handles a packed binary frame, only draw messages have a binary input layout
+func (room *Room) handleBinaryMessage(message []byte, player Player) (Frame, error) {
+	code, body, err := decodeBinaryFrame(message)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	switch code {
+	case DrawCode:
+		circles, err := unpackCircles(body)
+		if err != nil || len(circles) != 1 {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleDrawMessage(circles[0], player, "")
+	case StrokeCode:
+		stroke, err := unpackStroke(body)
+		if err != nil {
+			return Frame{}, ErrUnMarshal
+		}
+		return room.handleStrokeMessage(stroke, player, "")
+	default:
+		log.Println("Cannot handle unknown binary message type")
+		return Frame{}, errors.New("No matching binary message types for message")
 	}
 }
 
 type BeginMsg struct {
 	NextWord        string `json:"nextWord"`
 	NextPlayerIndex int    `json:"nextPlayerIndex"`
+	Deadline        int64  `json:"deadline"` // unix time in milliseconds the turn ends at, clients count down to it
+}
+
+func createBeginMsg(state *GameState) BeginMsg {
+	return BeginMsg{
+		NextWord:        state.turn.currWord,
+		NextPlayerIndex: state.drawerIndex(),
+		Deadline:        state.TurnDeadline().UnixMilli(),
+	}
+}
+
+// the turn waits for the drawer to choose the word, or for the describer to describe it in telephone mode
+type ChooseMsg struct {
+	NextPlayerIndex int   `json:"nextPlayerIndex"`
+	DescriberIndex  int   `json:"describerIndex"` // -1 unless the turn has a describer
+	Deadline        int64 `json:"deadline"`       // unix time in milliseconds a word is picked for the drawer at
+}
+
+// the candidates are only sent to the drawer
+type WordsMsg struct {
+	Words []string `json:"words"`
+}
+
+type PickMsg struct {
+	Index int `json:"index"`
+}
+
+// starts the next turn, either the drawer is sent the candidates to choose the word from, the describer is sent the
+// word to describe or the turn begins right away, only one of the returned messages is set
+func (room *Room) beginTurn() (*BeginMsg, *ChooseMsg) {
+	state := &room.state
+
+	state.StartGame()
+	if !state.turn.choosing && !state.turn.describing {
+		room.startDrawing()
+		msg := createBeginMsg(state)
+		return &msg, nil
+	}
+
+	if state.turn.describing {
+		room.startTurnTimer(DescribeTimeSecs)
+		wordResp, err := createResponse(WordCode, WordMsg{Word: state.turn.currWord})
+		if err != nil {
+			log.Println("Failed to serialize word for ws message")
+		} else {
+			room.sendLater(state.players[state.turn.describer].ID, wordResp)
+		}
+	} else {
+		room.startTurnTimer(state.settings.ChoiceTimeSecs)
+		wordsResp, err := createResponse(WordsCode, WordsMsg{Words: state.turn.candidates})
+		if err != nil {
+			log.Println("Failed to serialize words for ws message")
+		} else {
+			room.sendLater(state.GetCurrPlayer().ID, wordsResp)
+		}
+	}
+
+	msg := ChooseMsg{
+		NextPlayerIndex: state.drawerIndex(),
+		DescriberIndex:  state.turn.describer,
+		Deadline:        state.TurnDeadline().UnixMilli(),
+	}
+	return nil, &msg
+}
+
+// the turn timer, hints and relay start once the drawer starts drawing
+func (room *Room) startDrawing() {
+	room.startTurnTimer(room.state.TimeLimit())
+	room.startHintTimer()
+	room.startRelayTimer()
+}
+
+func createTurnResponse(state *GameState, beginMsg *BeginMsg, chooseMsg *ChooseMsg, traceID string) (Frame, error) {
+	if chooseMsg != nil {
+		return createTracedResponse(ChooseCode, *chooseMsg, traceID)
+	}
+	return createBeginResponse(state, BeginCode, beginMsg, traceID, func(msg *BeginMsg) BeginMsg {
+		return *msg
+	})
+}
+
+// creates a response containing the begin message, the players who can see the word receive it while every other
+// player receives it masked, so the word can't be read from the messages sent to a guesser
+func createBeginResponse[T any](state *GameState, code int, beginMsg *BeginMsg, traceID string,
+	build func(msg *BeginMsg) T) (Frame, error) {
+	if beginMsg == nil {
+		return createTracedResponse(code, build(nil), traceID)
+	}
+
+	masked := *beginMsg
+	masked.NextWord = state.MaskedWord()
+	frame, err := createTracedResponse(code, build(&masked), traceID)
+	if err != nil {
+		return Frame{}, err
+	}
+	revealed, err := createTracedResponse(code, build(beginMsg), traceID)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	frame.Views = make(map[uuid.UUID]Frame)
+	for _, player := range state.players {
+		if state.CanSeeWord(player) {
+			frame.Views[player.ID] = revealed
+		}
+	}
+	return frame, nil
 }
 
-func (room *Room) handleStartMessage(player Player, traceID string) ([]byte, error) {
+// the masked word with the letters revealed so far
+type HintMsg struct {
+	Pattern string `json:"pattern"`
+}
+
+// reveals another letter of the word, the response is empty if every letter that can be revealed already was
+func (room *Room) HandleHint() (Frame, error) {
+	state := &room.state
+	if state.stage != Playing || state.turn.choosing || state.turn.describing || !state.RevealHint() {
+		return Frame{}, nil
+	}
+	return createResponse(HintCode, HintMsg{Pattern: state.MaskedWord()})
+}
+
+// sent to a player once it guesses the word, and to the describer of a telephone turn
+type WordMsg struct {
+	Word string `json:"word"`
+}
+
+func (room *Room) handlePickMessage(msg PickMsg, player Player, traceID string) (Frame, error) {
+	state := &room.state
+
+	if state.stage != Playing || player.ID != state.GetCurrPlayer().ID {
+		return Frame{}, errors.New("Only the drawer can choose the word")
+	}
+	err := state.ChooseWord(msg.Index)
+	if err != nil {
+		return Frame{}, err
+	}
+	return room.createPickResponse(traceID)
+}
+
+// picks a random candidate for a drawer that didn't choose in time
+func (room *Room) HandleChoiceTimeout() (Frame, error) {
+	state := &room.state
+	log.Printf("Picking a word for the drawer for code %s", state.code)
+
+	err := state.ChooseWord(rand.Intn(len(state.turn.candidates)))
+	if err != nil {
+		return Frame{}, err
+	}
+	return room.createPickResponse("")
+}
+
+// the drawing time starts once the word is chosen
+func (room *Room) createPickResponse(traceID string) (Frame, error) {
+	state := &room.state
+	room.startDrawing()
+	msg := createBeginMsg(state)
+	return createTurnResponse(state, &msg, nil, traceID)
+}
+
+type DescribeMsg struct {
+	Text string `json:"text"`
+}
+
+// the description is only sent to the drawer
+type DescriptionMsg struct {
+	Description string `json:"description"`
+}
+
+func (room *Room) handleDescribeMessage(msg DescribeMsg, player Player, traceID string) (Frame, error) {
+	err := room.state.Describe(player, msg.Text)
+	if err != nil {
+		return Frame{}, err
+	}
+	return room.createDescribedResponse(traceID)
+}
+
+// a describer that didn't describe the word in time leaves the drawer to draw the word itself
+func (room *Room) HandleDescribeTimeout() (Frame, error) {
+	state := &room.state
+	log.Printf("Giving the word to the drawer for code %s", state.code)
+
+	state.setDescription(state.turn.currWord)
+	return room.createDescribedResponse("")
+}
+
+// the turn begins like it does once a word is chosen
+func (room *Room) createDescribedResponse(traceID string) (Frame, error) {
+	state := &room.state
+	descriptionResp, err := createResponse(DescriptionCode, DescriptionMsg{Description: state.turn.description})
+	if err != nil {
+		return Frame{}, err
+	}
+	room.sendLater(state.GetCurrPlayer().ID, descriptionResp)
+	return room.createPickResponse(traceID)
+}
+
+// the canvas is handed to the next drawer of a relay
+type RelayMsg struct {
+	NextPlayerIndex int `json:"nextPlayerIndex"`
+}
+
+// the response is empty if there is no drawer left to hand the canvas to
+func (room *Room) HandleRelay() (Frame, error) {
+	state := &room.state
+	if state.stage != Playing || !state.PassCanvas() {
+		return Frame{}, nil
+	}
+	return createResponse(RelayCode, RelayMsg{NextPlayerIndex: state.drawerIndex()})
+}
+
+func (room *Room) handleStartMessage(player Player, traceID string) (Frame, error) {
 	state := &room.state
 
 	if state.PlayerIsNotHost(player) {
-		return nil, errors.New("Player must be the host to start the game")
+		return Frame{}, errors.New("Player must be the host to start the game")
 	}
 	if state.stage == Playing {
-		return nil, errors.New("Cannot start a game that is already started")
+		return Frame{}, errors.New("Cannot start a game that is already started")
+	}
+	if state.stage == Post {
+		return Frame{}, errors.New("Cannot start a game that is finished, start a rematch instead")
+	}
+	if err := state.TeamsReady(); err != nil {
+		return Frame{}, err
 	}
 
-	state.StartGame()
+	beginMsg, chooseMsg := room.beginTurn()
+	room.setExpiration(state.settings.MaxGameSecs)
+
+	return createTurnResponse(state, beginMsg, chooseMsg, traceID)
+}
+
+type RematchMsg struct {
+	Settings *RoomSettings `json:"settings"` // settings for the next game, only the host can change them
+}
+
+type VoteMsg struct {
+	Player Player `json:"player"`
+	Votes  int    `json:"votes"`
+	Needed int    `json:"needed"`
+}
+
+type RestartMsg struct {
+	Settings  RoomSettings `json:"settings"`
+	Match     Match        `json:"match"` // the finished game that was archived
+	BeginMsg  *BeginMsg    `json:"beginMsg"`
+	ChooseMsg *ChooseMsg   `json:"chooseMsg"`
+}
+
+// restarts a finished game with the same players, the host restarts it right away while other players vote for it
+// and the game restarts once a majority of the players voted
+func (room *Room) handleRematchMessage(msg RematchMsg, player Player, traceID string) (Frame, error) {
+	state := &room.state
 
-	room.startResetTimer(state.settings.TimeLimitSecs)
-	room.postponeExpiration()
+	if state.stage != Post {
+		return Frame{}, errors.New("Cannot start a rematch before the game is finished")
+	}
 
-	msg := BeginMsg{NextWord: state.turn.currWord, NextPlayerIndex: state.turn.currPlayerIndex}
-	return createTracedResponse(BeginCode, msg, traceID)
+	settings := state.settings
+	if state.PlayerIsNotHost(player) {
+		if msg.Settings != nil {
+			return Frame{}, errors.New("Player must be the host to change the settings")
+		}
+		votes, needed := state.VoteRematch(player)
+		if votes < needed {
+			voteMsg := VoteMsg{Player: player, Votes: votes, Needed: needed}
+			return createTracedResponse(VoteCode, voteMsg, traceID)
+		}
+	} else if msg.Settings != nil {
+		settings = *msg.Settings
+		SettingsWithDefaults(&settings)
+		settings.SharedWordBank = state.settings.SharedWordBank
+		// the visibility and draw coalescing of a room are fixed once it is created
+		settings.IsPublic = state.settings.IsPublic
+		settings.CoalesceMs = state.settings.CoalesceMs
+
+		err := IsSettingsValid(settings)
+		if err != nil {
+			return Frame{}, err
+		}
+		if len(state.Players()) > settings.PlayerLimit {
+			return Frame{}, errors.New("Player limit must allow every player in the room to stay")
+		}
+	}
+
+	match := state.Rematch(settings)
+
+	beginMsg, chooseMsg := room.beginTurn()
+	room.setExpiration(state.settings.MaxGameSecs)
+
+	restartMsg := RestartMsg{Settings: state.settings, Match: match, ChooseMsg: chooseMsg}
+	return createBeginResponse(state, RestartCode, beginMsg, traceID, func(msg *BeginMsg) RestartMsg {
+		restartMsg.BeginMsg = msg
+		return restartMsg
+	})
+}
+
+type SpectatorsMsg struct {
+	Count int `json:"count"`
+}
+
+// spectators can only send chat, which is only sent to the other spectators
+func (room *Room) HandleSpectatorMessage(message []byte, spectator Player) (Frame, error) {
+	if !room.state.settings.SpectatorChat {
+		return Frame{}, errors.New("Spectators cannot chat in this room")
+	}
+	var payload InputPayload[json.RawMessage]
+	err := json.Unmarshal(message, &payload)
+	if err != nil {
+		return Frame{}, err
+	}
+	if payload.Code != TextCode {
+		return Frame{}, errors.New("Spectators can only chat")
+	}
+
+	var msg TextMsg
+	err = json.Unmarshal(payload.Msg, &msg)
+	if err != nil {
+		return Frame{}, ErrUnMarshal
+	}
+	if len(msg.Text) > MaxChatLen || len(msg.Text) < MinChatLen {
+		return Frame{}, fmt.Errorf("Chat message must be less than %d characters in length and more than %d", MaxChatLen, MinChatLen)
+	}
+	return createTracedResponse(SpectatorChatCode, Chat{Player: spectator, Text: msg.Text}, payload.TraceID)
+}
+
+type TeamMsg struct {
+	Team int `json:"team"`
+}
+
+type TeamsMsg struct {
+	Teams map[uuid.UUID]int `json:"teams"`
+}
+
+func (room *Room) handleTeamMessage(msg TeamMsg, player Player, traceID string) (Frame, error) {
+	err := room.state.ChooseTeam(player, msg.Team)
+	if err != nil {
+		return Frame{}, err
+	}
+	return createTeamsResponse(&room.state, traceID)
+}
+
+// the response is empty unless the room is in team mode
+func createTeamsResponse(state *GameState, traceID string) (Frame, error) {
+	if !state.IsTeamMode() {
+		return Frame{}, nil
+	}
+	return createTracedResponse(TeamsCode, TeamsMsg{Teams: state.Teams()}, traceID)
+}
+
+type TransferMsg struct {
+	PlayerID uuid.UUID `json:"playerId"`
+}
+
+type HostMsg struct {
+	Player Player `json:"player"`
+}
+
+// hands the host to another player in the room
+func (room *Room) handleTransferMessage(msg TransferMsg, player Player, traceID string) (Frame, error) {
+	state := &room.state
+
+	if state.PlayerIsNotHost(player) {
+		return Frame{}, errors.New("Player must be the host to transfer the host")
+	}
+	err := state.TransferHost(Player{ID: msg.PlayerID})
+	if err != nil {
+		return Frame{}, err
+	}
+	return createHostResponse(state, traceID)
+}
+
+func createHostResponse(state *GameState, traceID string) (Frame, error) {
+	host := state.hostPlayer()
+	if host == nil {
+		return Frame{}, nil
+	}
+	return createTracedResponse(HostCode, HostMsg{Player: *host}, traceID)
 }
 
 type TextMsg struct {
 	Text string `json:"text"`
 }
 
-func (room *Room) handleTextMessage(msg TextMsg, player Player, traceID string) ([]byte, error) {
+// sent only to a guesser whose guess was close to the word
+type CloseMsg struct {
+	Text string `json:"text"`
+}
+
+func (room *Room) handleTextMessage(msg TextMsg, player Player, traceID string) (Frame, error) {
 	text := msg.Text
 	if len(text) > MaxChatLen || len(text) < MinChatLen {
-		return nil, fmt.Errorf("Chat message must be less than %d characters in length and more than %d", MaxChatLen, MinChatLen)
+		return Frame{}, fmt.Errorf("Chat message must be less than %d characters in length and more than %d", MaxChatLen, MinChatLen)
+	}
+
+	if room.state.DrawsDescription(player) {
+		return Frame{}, errors.New("The drawer cannot chat while drawing a description")
+	}
+
+	// players who can see the word could give it away in the chat, so they chat in the guessed channel
+	if room.state.InGuessedChannel(player) {
+		chat, err := room.state.GuessedChat(player, text)
+		if err != nil {
+			return Frame{}, err
+		}
+		chatResp, err := createTracedResponse(ChatCode, chat, traceID)
+		if err != nil {
+			return Frame{}, err
+		}
+		for _, p := range room.state.Players() {
+			if room.state.CanSeeWord(p) {
+				room.sendLater(p.ID, chatResp)
+			}
+		}
+		return Frame{}, nil
+	}
+
+	// a close guess would give the word away to the other guessers, so only the guesser is told it was close
+	if room.state.IsCloseGuess(player, text) {
+		closeResp, err := createTracedResponse(CloseCode, CloseMsg{Text: text}, traceID)
+		if err != nil {
+			return Frame{}, err
+		}
+		room.sendLater(player.ID, closeResp)
+		return Frame{}, nil
 	}
 
 	chat := room.state.TryGuess(player, text)
 	log.Printf("Chat message, %+v: %s", player, msg.Text)
 
+	// the chat only tells the other players the guess was right, the guesser is sent the word itself
+	if chat.GuessPointsInc > 0 {
+		wordResp, err := createResponse(WordCode, WordMsg{Word: room.state.turn.currWord})
+		if err != nil {
+			return Frame{}, err
+		}
+		room.sendLater(player.ID, wordResp)
+	}
+
 	return createTracedResponse(ChatCode, chat, traceID)
 }
 
 type DrawMsg = Circle
 
-func (room *Room) handleDrawMessage(msg DrawMsg, player Player, traceID string) ([]byte, error) {
+type Point struct {
+	X uint16 `json:"x"`
+	Y uint16 `json:"y"`
+}
+
+// a polyline of points drawn with the same color and radius
+type StrokeMsg struct {
+	Color     uint8   `json:"color"`
+	Radius    uint8   `json:"radius"`
+	Connected bool    `json:"connected"` // whether the first point continues the previous stroke
+	Points    []Point `json:"points"`
+}
+
+// expands the stroke into the circles stored on the canvas, each point after the first connects to the one before it
+func (stroke StrokeMsg) Circles() []Circle {
+	circles := make([]Circle, len(stroke.Points))
+	for i, point := range stroke.Points {
+		circles[i] = Circle{
+			Color:     stroke.Color,
+			Radius:    stroke.Radius,
+			X:         point.X,
+			Y:         point.Y,
+			Connected: i > 0 || stroke.Connected,
+		}
+	}
+	return circles
+}
+
+type DrawBatchMsg = []Circle
+
+func (room *Room) canDraw(player Player) error {
 	state := &room.state
 
 	if state.stage != Playing {
-		return nil, errors.New("Can't draw on canvas when game is not being played")
+		return errors.New("Can't draw on canvas when game is not being played")
+	}
+	if state.turn.choosing {
+		return errors.New("Can't draw on canvas before the word is chosen")
+	}
+	if state.turn.describing {
+		return errors.New("Can't draw on canvas before the word is described")
 	}
 	if player.ID != state.GetCurrPlayer().ID {
-		return nil, errors.New("Player cannot draw on the canvas")
+		return errors.New("Player cannot draw on the canvas")
+	}
+	return nil
+}
+
+func validateCircle(circle Circle) error {
+	if circle.X < 0 || circle.X > MaxX || circle.Y < 0 || circle.Y > MaxY {
+		return errors.New("Cannot draw outside canvas")
+	}
+	if circle.Radius < 0 || circle.Radius > MaxRadius {
+		return fmt.Errorf("Unknown code for radius %d", circle.Radius)
+	}
+	if circle.Color < 0 || circle.Color > MaxColor {
+		return fmt.Errorf("Unknown code for color %d", circle.Color)
+	}
+	return nil
+}
+
+func (room *Room) handleDrawMessage(msg DrawMsg, player Player, traceID string) (Frame, error) {
+	if err := room.canDraw(player); err != nil {
+		return Frame{}, err
+	}
+	if err := validateCircle(msg); err != nil {
+		return Frame{}, err
 	}
-	if msg.X < 0 || msg.X > MaxX || msg.Y < 0 || msg.Y > MaxY {
-		return nil, errors.New("Cannot draw outside canvas")
+
+	room.state.Draw(msg)
+	// draws are sent by the room itself, since batching subscribers may receive them later than everyone else
+	return Frame{}, room.broadcastDraws([]Circle{msg}, traceID)
+}
+
+func (room *Room) handleStrokeMessage(msg StrokeMsg, player Player, traceID string) (Frame, error) {
+	if err := room.canDraw(player); err != nil {
+		return Frame{}, err
 	}
-	if msg.Radius < 0 || msg.Radius > MaxRadius {
-		return nil, fmt.Errorf("Unknown code for radius %d", msg.Radius)
+	if len(msg.Points) < 1 || len(msg.Points) > MaxStrokePoints {
+		return Frame{}, fmt.Errorf("Strokes must contain between 1 and %d points", MaxStrokePoints)
 	}
-	if msg.Color < 0 || msg.Color > MaxColor {
-		return nil, fmt.Errorf("Unknown code for color %d", msg.Color)
+
+	circles := msg.Circles()
+	for _, circle := range circles {
+		if err := validateCircle(circle); err != nil {
+			return Frame{}, err
+		}
 	}
 
-	state.Draw(msg)
-	return createTracedResponse(DrawCode, msg, traceID)
+	for _, circle := range circles {
+		room.state.Draw(circle)
+	}
+	return Frame{}, room.broadcastDraws(circles, traceID)
+}
+
+func createDrawResponse(msg DrawMsg, traceID string) (Frame, error) {
+	frame, err := createTracedResponse(DrawCode, msg, traceID)
+	if err != nil {
+		return Frame{}, err
+	}
+	frame.Binary, err = createBinaryDraw(msg)
+	return frame, err
+}
+
+func createDrawBatchResponse(msg DrawBatchMsg) (Frame, error) {
+	frame, err := createResponse(DrawBatchCode, msg)
+	if err != nil {
+		return Frame{}, err
+	}
+	frame.Binary, err = createBinaryBatch(msg)
+	return frame, err
 }
 
 type PlayerMsg struct {
 	PlayerIndex int    `json:"playerIndex"` // ensures ordering of players on client and server are the same
 	Player      Player `json:"player"`
+	Queued      bool   `json:"queued"` // whether the player waits for the next round to draw
 }
 
-func (room *Room) HandleJoin(player Player) ([]byte, error) {
+func (room *Room) HandleJoin(player Player) (Frame, error) {
 	state := &room.state
 
 	err := state.Join(player)
 	if err != nil {
-		return nil, err
+		return Frame{}, err
 	}
 
-	lastIndex := len(state.players) - 1
-	msg := PlayerMsg{PlayerIndex: lastIndex, Player: player}
+	// a rejoining player keeps its place in the drawing order
+	index := state.playerIndex(player)
+	msg := PlayerMsg{PlayerIndex: index, Player: player, Queued: state.queued[player.ID]}
 	return createResponse(JoinCode, msg)
 }
 
-func HandleLeave(state *GameState, player Player) ([]byte, error) {
+func HandleLeave(state *GameState, player Player) (Frame, error) {
 	leaveIndex := state.Leave(player)
 	if leaveIndex < 0 {
-		return nil, errors.New("Failed to leave the state, player couldn't be found")
+		return Frame{}, errors.New("Failed to leave the state, player couldn't be found")
 	}
 
 	msg := PlayerMsg{PlayerIndex: leaveIndex, Player: player}
@@ -177,47 +776,99 @@ func HandleLeave(state *GameState, player Player) ([]byte, error) {
 }
 
 type FinishMsg struct {
-	BeginMsg     *BeginMsg `json:"beginMsg"`
-	DrawScoreInc int       `json:"drawScoreInc"`
+	BeginMsg     *BeginMsg  `json:"beginMsg"`
+	ChooseMsg    *ChooseMsg `json:"chooseMsg"` // set instead of the begin message when the next drawer chooses the word
+	DrawScoreInc int        `json:"drawScoreInc"`
+	Word         string     `json:"word"` // the word of the finished turn, revealed to everyone
 }
 
-func (room *Room) HandleReset() ([]byte, error) {
+func (room *Room) HandleReset() (Frame, error) {
 	state := &room.state
 	log.Printf("Resetting the game for code %s", state.code)
 
-	room.postponeExpiration()
-
+	// the drawer keeps the points for the guesses made during the turn, even if they left before it ended
 	pointsInc := state.OnReset()
+	word := state.turn.currWord
 
 	var beginMsg *BeginMsg = nil
+	var chooseMsg *ChooseMsg = nil
 	if state.HasMoreRounds() {
-		state.StartGame()
-		room.startResetTimer(state.settings.TimeLimitSecs)
-
-		beginMsg = &BeginMsg{NextWord: state.turn.currWord, NextPlayerIndex: state.turn.currPlayerIndex}
+		beginMsg, chooseMsg = room.beginTurn()
 	} else {
 		state.FinishGame()
+		room.setExpiration(state.settings.PostStageSecs)
 	}
 
-	msg := FinishMsg{BeginMsg: beginMsg, DrawScoreInc: pointsInc}
+	msg := FinishMsg{ChooseMsg: chooseMsg, DrawScoreInc: pointsInc, Word: word}
+	return createBeginResponse(state, FinishCode, beginMsg, "", func(beginMsg *BeginMsg) FinishMsg {
+		msg.BeginMsg = beginMsg
+		return msg
+	})
+}
+
+// finishes a game that ran past the max game length without scoring the turn in progress
+func (room *Room) HandleGameTimeout() (Frame, error) {
+	state := &room.state
+	log.Printf("Game for code %s ran out of time", state.code)
+
+	state.FinishGame()
+	room.setExpiration(state.settings.PostStageSecs)
+
+	msg := FinishMsg{BeginMsg: nil, DrawScoreInc: 0, Word: state.turn.currWord}
 	return createResponse(FinishCode, msg)
 }
- 	
-func (room *Room) HandleState() ([]byte, error) {
+
+type ExpiryMsg struct {
+	ExpireTime    int64 `json:"expireTime"` // unix time in seconds
+	RemainingSecs int64 `json:"remainingSecs"`
+}
+
+func (room *Room) createExpiryResponse(now time.Time, traceID string) (Frame, error) {
+	expireTime := room.expireTime.Load()
+	msg := ExpiryMsg{ExpireTime: expireTime, RemainingSecs: expireTime - now.Unix()}
+	return createTracedResponse(ExpiryCode, msg, traceID)
+}
+
+// extends the room by another idle timeout, only once players were warned it is about to expire
+func (room *Room) handleExtendMessage(player Player, traceID string) (Frame, error) {
+	state := &room.state
+
+	if state.PlayerIsNotHost(player) {
+		return Frame{}, errors.New("Player must be the host to extend the room")
+	}
+	if state.stage == Playing {
+		return Frame{}, errors.New("Cannot extend a game that is in progress")
+	}
+	now := state.clock.Now()
+	if room.expireTime.Load()-now.Unix() > ExpiryWarningSecs {
+		return Frame{}, errors.New("Room can only be extended when it is about to expire")
+	}
+
+	room.expireTime.Add(int64(state.settings.IdleTimeoutSecs))
+	room.warned = false
+
+	return room.createExpiryResponse(now, traceID)
+}
+
+// creates the state message for a single subscriber in the format it negotiated
+// the state is sent to a single player, so it contains the word only if that player can see it
+func (room *Room) HandleState(protocol Protocol, player Player) (Frame, error) {
 	state := &room.state
-	bytes := state.MarshalJson()
-	return createResponse[json.RawMessage](StateCode, bytes)
+	if protocol.Binary {
+		return Frame{Binary: state.EncodeBinary(player, protocol.Compression)}, nil
+	}
+	return createResponse[json.RawMessage](StateCode, state.MarshalJson(player, protocol.Compression))
 }
 
-func createResponse[T any](code int, msg T) ([]byte, error) {
+func createResponse[T any](code int, msg T) (Frame, error) {
 	return createTracedResponse(code, msg, "")
 }
 
-func createTracedResponse[T any](code int, msg T, traceID string) ([]byte, error) {
+func createTracedResponse[T any](code int, msg T, traceID string) (Frame, error) {
 	payload := OutputPayload[T]{Code: code, Msg: msg, TraceID: traceID}
 	buf, err := json.Marshal(payload)
 	if err != nil {
-		return nil, ErrMarshal
+		return Frame{}, ErrMarshal
 	}
-	return buf, nil
+	return Frame{Text: buf}, nil
 }
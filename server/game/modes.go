@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+const (
+	ClassicMode   = "classic"
+	SpeedMode     = "speed"
+	RelayMode     = "relay"
+	TelephoneMode = "telephone"
+
+	SpeedTimeLimit   = 20  // seconds each turn lasts at most in speed mode
+	RelayDrawers     = 3   // players who take turns drawing on the canvas of a relay turn
+	DescribeTimeSecs = 30  // seconds the describer of a telephone turn has to describe the word
+	MaxDescribeLen   = 100 // characters a description can be
+)
+
+// decides how a game moves from turn to turn and how the turns are scored, each room picks a mode in its settings
+type GameMode interface {
+	// the seconds players have to draw and guess the word of each turn
+	TimeLimit(settings RoomSettings) int
+	// moves to the players taking the next turn, the round advances once every player had a turn
+	NextTurn(state *GameState)
+	// the policy scoring the guesses and drawings of each turn
+	Scoring(settings RoomSettings) ScoringPolicy
+}
+
+var gameModes = map[string]GameMode{
+	ClassicMode:   ClassicGame{},
+	SpeedMode:     SpeedGame{},
+	RelayMode:     RelayGame{},
+	TelephoneMode: TelephoneGame{},
+}
+
+// the players take turns drawing a word for the others to guess
+type ClassicGame struct{}
+
+func (mode ClassicGame) TimeLimit(settings RoomSettings) int {
+	return settings.TimeLimitSecs
+}
+
+func (mode ClassicGame) NextTurn(state *GameState) {
+	state.cycleCurrPlayer()
+	state.turn.drawers = []int{state.turn.currPlayerIndex}
+}
+
+// the policy picked in the settings, settings from before policies could be picked use the default
+func (mode ClassicGame) Scoring(settings RoomSettings) ScoringPolicy {
+	policy, ok := scoringPolicies[settings.Scoring]
+	if !ok {
+		return DecayScoring{}
+	}
+	return policy
+}
+
+// classic turns on a short timer, where only the quickest guessers score
+type SpeedGame struct {
+	ClassicGame
+}
+
+func (mode SpeedGame) TimeLimit(settings RoomSettings) int {
+	return min(settings.TimeLimitSecs, SpeedTimeLimit)
+}
+
+func (mode SpeedGame) Scoring(_ RoomSettings) ScoringPolicy {
+	return SpeedScoring{}
+}
+
+// several players draw the word of each turn on the same canvas, passing it on after an equal share of the time
+type RelayGame struct {
+	ClassicGame
+}
+
+// the players after the drawer join it until the relay is full, leaving at least one player to guess the word
+func (mode RelayGame) NextTurn(state *GameState) {
+	state.cycleCurrPlayer()
+
+	first := state.turn.currPlayerIndex
+	drawers := []int{first}
+	size := min(RelayDrawers, len(state.Players())-1)
+	for i := 1; i < len(state.players) && len(drawers) < size; i++ {
+		index := (first + i) % len(state.players)
+		if state.canDraw(index) {
+			drawers = append(drawers, index)
+		}
+	}
+	state.turn.drawers = drawers
+}
+
+// the drawers of a relay share the points for their drawing
+func (mode RelayGame) Scoring(settings RoomSettings) ScoringPolicy {
+	return SharedScoring{Policy: mode.ClassicGame.Scoring(settings)}
+}
+
+// the describer of each turn describes the word, and the next player draws the description without seeing the word
+type TelephoneGame struct {
+	ClassicGame
+}
+
+// every player describes once and draws once each round, a player without anyone to draw for it draws the word itself
+func (mode TelephoneGame) NextTurn(state *GameState) {
+	state.cycleCurrPlayer()
+
+	describer := state.turn.currPlayerIndex
+	for i := 1; i < len(state.players); i++ {
+		index := (describer + i) % len(state.players)
+		if state.canDraw(index) {
+			state.turn.describer = describer
+			state.turn.describing = true
+			state.turn.drawers = []int{index}
+			return
+		}
+	}
+	state.turn.drawers = []int{describer}
+}
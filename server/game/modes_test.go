@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"github.com/google/uuid"
+	"slices"
+	"testing"
+)
+
+func MockModeSettings(mode string) RoomSettings {
+	settings := MockSettings()
+	settings.Mode = mode
+	settings.SharedWordBank = []string{"quick"}
+	return settings
+}
+
+func TestSettings_GameMode(t *testing.T) {
+	settings := MockSettings()
+	if settings.Mode != ClassicMode {
+		t.Fatalf("Expected the default game mode to be %s, got %s", ClassicMode, settings.Mode)
+	}
+	settings.Mode = "unknown"
+	if IsSettingsValid(settings) == nil {
+		t.Fatalf("Expected an unknown game mode to be invalid")
+	}
+	settings = MockModeSettings(RelayMode)
+	settings.TeamCount = 2
+	if IsSettingsValid(settings) == nil {
+		t.Fatalf("Expected teams to be invalid in relay mode")
+	}
+}
+
+func TestSpeedGame_TimeLimit(t *testing.T) {
+	state := NewGameState("123", MockModeSettings(SpeedMode))
+	if state.TimeLimit() != SpeedTimeLimit {
+		t.Fatalf("Expected speed turns to last %d seconds, got %d", SpeedTimeLimit, state.TimeLimit())
+	}
+	state.settings.TimeLimitSecs = MinTimeLimit
+	if state.TimeLimit() != MinTimeLimit {
+		t.Fatalf("Expected a shorter time limit to be kept, got %d", state.TimeLimit())
+	}
+}
+
+func TestRelayGame_NextTurn(t *testing.T) {
+	state := NewGameState("123", MockModeSettings(RelayMode))
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.StartGame()
+
+	// the drawer and the players after it draw, leaving the first player to guess
+	if !slices.Equal(state.turn.drawers, []int{1, 2, 3}) {
+		t.Fatalf("Expected the relay to be players 1, 2 and 3, got %v", state.turn.drawers)
+	}
+	if !state.CanSeeWord(players[3]) || state.canGuess(players[3]) || !state.canGuess(players[0]) {
+		t.Fatalf("Expected every drawer of the relay to see the word and only the others to guess it")
+	}
+
+	// an absent drawer is skipped when the canvas is passed on
+	state.Leave(players[2])
+	if !state.PassCanvas() || state.GetCurrPlayer().ID != players[3].ID {
+		t.Fatalf("Expected the canvas to be passed to player 3, got %v", state.GetCurrPlayer())
+	}
+	if state.PassCanvas() {
+		t.Fatalf("Expected the last drawer to keep the canvas")
+	}
+
+	// the drawers share the points for the drawing
+	state.TryGuess(players[0], "quick")
+	pointsInc := state.OnReset()
+	if pointsInc != MaxDrawPoints/3 {
+		t.Fatalf("Expected each drawer to score %d points, got %d", MaxDrawPoints/3, pointsInc)
+	}
+	for _, player := range players[1:] {
+		if state.scoreBoard[player.ID].Points != pointsInc {
+			t.Fatalf("Expected drawer %v to score %d points, got %d", player, pointsInc, state.scoreBoard[player.ID].Points)
+		}
+	}
+}
+
+func TestTelephoneGame_Describe(t *testing.T) {
+	state := NewGameState("123", MockModeSettings(TelephoneMode))
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.StartGame()
+
+	// the second player describes the word for the third player to draw
+	describer, drawer, guesser := players[1], players[2], players[0]
+	if !state.turn.describing || !state.isDescriber(describer) || state.GetCurrPlayer().ID != drawer.ID {
+		t.Fatalf("Expected player 1 to describe the word for player 2")
+	}
+	if state.canGuess(guesser) {
+		t.Fatalf("Expected nobody to guess before the word is described")
+	}
+
+	if state.Describe(drawer, "a fast animal") == nil {
+		t.Fatalf("Expected only the describer to describe the word")
+	}
+	if state.Describe(describer, "it is quik") == nil {
+		t.Fatalf("Expected a description giving the word away to be rejected")
+	}
+	if err := state.Describe(describer, "the opposite of slow"); err != nil {
+		t.Fatalf("Expected the description to be accepted, got %v", err)
+	}
+
+	// the drawer only sees the description, while the guesser sees neither
+	if state.CanSeeWord(drawer) || !state.CanSeeWord(describer) || !state.DrawsDescription(drawer) {
+		t.Fatalf("Expected only the describer to see the word")
+	}
+	if state.descriptionFor(drawer) != "the opposite of slow" || state.descriptionFor(guesser) != "" {
+		t.Fatalf("Expected only the players on the turn to see the description")
+	}
+
+	// the describer and the drawer are both scored for the guess
+	if chat := state.TryGuess(guesser, "quick"); chat.GuessPointsInc == 0 {
+		t.Fatalf("Expected the guesser to guess the word")
+	}
+	pointsInc := state.OnReset()
+	if state.scoreBoard[describer.ID].Points != pointsInc || state.scoreBoard[drawer.ID].Points != pointsInc {
+		t.Fatalf("Expected the describer and drawer to score %d points", pointsInc)
+	}
+}
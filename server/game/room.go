@@ -6,6 +6,8 @@ package game
 
 import (
 	"encoding/json"
+	"github.com/google/uuid"
+	"guessthesketch/pubsub"
 	"log"
 	"sync/atomic"
 	"time"
@@ -14,7 +16,7 @@ import (
 type Broker interface {
 	Start()
 	Join(m SubscriberMsg)
-	Leave(s chan []byte)
+	Leave(s chan pubsub.Message)
 	SendMessage(m SentMsg)
 	Stop(c int)
 	IsExpired(now time.Time) bool
@@ -24,57 +26,108 @@ type Broker interface {
 type EventHandler interface {
 	DoShutdown(results []GameResult)
 	DoCapture(snap Snapshot)
+	DoInterrupt(results []GameResult, snap Snapshot) // called when the server shuts down during a game
 	OnTermination()
 }
 
 type Room struct {
 	join        chan SubscriberMsg
-	leave       chan chan []byte
+	leave       chan chan pubsub.Message
 	sendMessage chan SentMsg
-	reset       chan struct{}
 	stop        chan int
+	done        chan struct{} // closed once the room has terminated
 
 	state       GameState
-	subscribers map[chan []byte]Player
-	expireTime  atomic.Int64
+	subscribers map[chan pubsub.Message]SubscriberMsg // subscribers of the room, remote ones are connected to other nodes
+	variants    map[Protocol]int                      // subscribers on each broadcast topic, topics without any aren't published to
+	pubsub      pubsub.PubSub                         // broadcasts reach subscribers on any node through the pubsub
+	pending     []Circle                              // draws waiting for the next flush to batching subscribers
+	expireTime  atomic.Int64                          // unix time in seconds the room expires at, or a game in progress is finished at
+	warned      bool                                  // whether subscribers were warned about the current expire time
+	turnTimer   Timer                                 // ends the current turn once its time limit runs out, nil between turns
+	hintTimer   Timer                                 // reveals the hints of the current turn, nil when no hints are left
+	relayTimer  Timer                                 // hands the canvas to the next drawer of a relay, nil without one
+	outbox      []PlayerFrame                         // frames for single players, sent after the message that caused them
 	isPublic    bool
 
 	handler EventHandler
 }
 
+type PlayerFrame struct {
+	PlayerID uuid.UUID
+	Frame    Frame
+}
+
 type SentMsg struct {
 	Message []byte
-	Sender  chan []byte
+	Binary  bool // whether the message was sent in a binary frame
+	Sender  chan pubsub.Message
 }
 
 type SubscriberMsg struct {
-	Subscriber chan []byte
+	Subscriber chan pubsub.Message
 	Player     Player
+	Protocol   Protocol
+	Spectator  bool   // spectators receive broadcasts without joining the game
+	ID         string // names the topic frames for only this subscriber are published on, set by the room if empty
+	Remote     bool   // the node that accepted the socket subscribed the channel to the topics, the room only tracks it
+}
+
+// the topics a subscriber receives frames on: the room's broadcasts in its encoding and the frames sent only to it
+func (m SubscriberMsg) Topics(code string) []string {
+	return []string{RoomTopic(code, m.Protocol), SubscriberTopic(code, m.ID)}
 }
 
 func NewRoom(initialState GameState, isPublic bool, handler EventHandler) *Room {
+	return NewRoomWithPubSub(initialState, isPublic, handler, pubsub.NewMemoryPubSub())
+}
+
+func NewRoomWithPubSub(initialState GameState, isPublic bool, handler EventHandler, ps pubsub.PubSub) *Room {
+	return NewRoomWithClock(initialState, isPublic, handler, ps, RealClock{})
+}
+
+func NewRoomWithClock(initialState GameState, isPublic bool, handler EventHandler, ps pubsub.PubSub, clock Clock) *Room {
+	initialState.clock = clock
+	initialState.turn.startTime = clock.Now()
 	room := &Room{
 		join:        make(chan SubscriberMsg),
-		leave:       make(chan chan []byte),
+		leave:       make(chan chan pubsub.Message),
 		sendMessage: make(chan SentMsg),
-		reset:       make(chan struct{}),
 		stop:        make(chan int),
+		done:        make(chan struct{}),
 		handler:     handler,
-		subscribers: make(map[chan []byte]Player),
+		subscribers: make(map[chan pubsub.Message]SubscriberMsg),
+		variants:    make(map[Protocol]int),
+		pubsub:      ps,
 		state:       initialState,
 		isPublic:    isPublic,
 	}
-	room.postponeExpiration()
+	room.setExpiration(initialState.settings.IdleTimeoutSecs)
 	return room
 }
 
 func (room *Room) Start() {
+	defer close(room.done)
 	defer func() {
 		log.Printf("Termination finished for room %s", room.state.code)
 		if panicInfo := recover(); panicInfo != nil {
 			log.Println("Fatal error in room: ", panicInfo)
 		}
 	}()
+
+	// accumulated draws are only flushed on a ticker when the room coalesces them
+	clock := room.state.clock
+	var flush <-chan time.Time
+	if room.state.settings.CoalesceMs > 0 {
+		ticker := clock.NewTicker(time.Duration(room.state.settings.CoalesceMs) * time.Millisecond)
+		defer ticker.Stop()
+		flush = ticker.C()
+	}
+
+	lifecycle := clock.NewTicker(time.Second)
+	defer lifecycle.Stop()
+	defer room.stopTurnTimer()
+
 	for {
 		select {
 		case subMsg := <-room.join:
@@ -83,8 +136,20 @@ func (room *Room) Start() {
 			room.onUnsubscribe(subscriber)
 		case sentMsg := <-room.sendMessage:
 			room.onMessage(sentMsg)
-		case <-room.reset:
-			room.onResetState()
+		case <-room.turnTimeout():
+			room.onTurnTimeout()
+		case <-room.hintTimeout():
+			room.onHint()
+		case <-room.relayTimeout():
+			room.onRelay()
+		case <-flush:
+			room.flushDraws()
+		case now := <-lifecycle.C():
+			if room.checkExpiration(now) {
+				room.onTerminate(TimeoutCode)
+				room.handler.OnTermination()
+				return
+			}
 		case termCode := <-room.stop:
 			room.onTerminate(termCode)
 			room.handler.OnTermination()
@@ -93,20 +158,38 @@ func (room *Room) Start() {
 	}
 }
 
+// each operation gives up once the room has terminated, so callers never block on a room that isn't running
+
 func (room *Room) Join(m SubscriberMsg) {
-	room.join <- m
+	select {
+	case room.join <- m:
+	case <-room.done:
+		// nothing will ever be sent to the subscriber
+		close(m.Subscriber)
+	}
 }
 
-func (room *Room) Leave(s chan []byte) {
-	room.leave <- s
+func (room *Room) Leave(s chan pubsub.Message) {
+	select {
+	case room.leave <- s:
+	case <-room.done:
+	}
 }
 
 func (room *Room) SendMessage(m SentMsg) {
-	room.sendMessage <- m
+	select {
+	case room.sendMessage <- m:
+	case <-room.done:
+	}
 }
 
+// stops the room and waits until it has finished terminating
 func (room *Room) Stop(c int) {
-	room.stop <- c
+	select {
+	case room.stop <- c:
+	case <-room.done:
+	}
+	<-room.done
 }
 
 func (room *Room) IsExpired(now time.Time) bool {
@@ -117,34 +200,329 @@ func (room *Room) IsPublic() bool {
 	return room.isPublic
 }
 
+// subscribers are warned this long before the room expires, giving the host time to extend it
+const ExpiryWarningSecs = 60
+
+func (room *Room) setExpiration(secs int) {
+	room.expireTime.Store(room.state.clock.Now().Unix() + int64(secs))
+	room.warned = false
+}
+
+// activity only keeps a room alive in the lobby, games and post game stages have a fixed length
 func (room *Room) postponeExpiration() {
-	// set the expiration time for 15 minutes
-	//room.expireTime.Store(time.Now().Unix() + 15*60)
-	room.expireTime.Store(time.Now().Unix() + 10)
+	if room.state.stage == Lobby {
+		room.setExpiration(room.state.settings.IdleTimeoutSecs)
+	}
 }
 
-func (room *Room) startResetTimer(timeSecs int) {
-	go func() {
-		time.Sleep(time.Duration(timeSecs) * time.Second)
-		room.reset <- struct{}{}
-	}()
+// whether the message is the host extending the room, only the code is read before the message is handled
+func isExtendMessage(sentMsg SentMsg) bool {
+	if sentMsg.Binary {
+		return false
+	}
+	var payload InputPayload[json.RawMessage]
+	return json.Unmarshal(sentMsg.Message, &payload) == nil && payload.Code == ExtendCode
+}
+
+// warns subscribers when the expire time is close and finishes games that ran out of time, returning true when
+// the room has expired and should terminate
+func (room *Room) checkExpiration(now time.Time) bool {
+	remainingSecs := room.expireTime.Load() - now.Unix()
+	if remainingSecs <= 0 {
+		if room.state.stage != Playing {
+			return true
+		}
+		room.onGameTimeout()
+		return false
+	}
+
+	if !room.warned && remainingSecs <= ExpiryWarningSecs {
+		room.warned = true
+		resp, err := room.createExpiryResponse(now, "")
+		if err != nil {
+			log.Println("Failed to serialize expiry warning for ws message")
+			return false
+		}
+		room.broadcast(resp)
+	}
+	return false
+}
+
+// the timer is owned by the room loop, so stopping it guarantees the turn it was started for won't be reset by it
+func (room *Room) startTurnTimer(timeSecs int) {
+	room.stopTurnTimer()
+	room.turnTimer = room.state.clock.NewTimer(time.Duration(timeSecs) * time.Second)
+}
+
+// the hints and relay of a turn stop with it
+func (room *Room) stopTurnTimer() {
+	if room.turnTimer != nil {
+		room.turnTimer.Stop()
+		room.turnTimer = nil
+	}
+	room.stopHintTimer()
+	room.stopRelayTimer()
+}
+
+// hints are spread evenly over the time limit of the turn, the last one is revealed before the time runs out
+func (room *Room) startHintTimer() {
+	room.stopHintTimer()
+	settings := room.state.settings
+	if settings.HintCount == 0 {
+		return
+	}
+	interval := time.Duration(room.state.TimeLimit()) * time.Second / time.Duration(settings.HintCount+1)
+	room.hintTimer = room.state.clock.NewTicker(interval)
+}
+
+func (room *Room) stopHintTimer() {
+	if room.hintTimer != nil {
+		room.hintTimer.Stop()
+		room.hintTimer = nil
+	}
+}
+
+func (room *Room) hintTimeout() <-chan time.Time {
+	if room.hintTimer == nil {
+		return nil
+	}
+	return room.hintTimer.C()
+}
+
+// each drawer of a relay holds the canvas for an equal share of the time limit
+func (room *Room) startRelayTimer() {
+	room.stopRelayTimer()
+	drawers := len(room.state.turn.drawers)
+	if drawers < 2 {
+		return
+	}
+	interval := time.Duration(room.state.TimeLimit()) * time.Second / time.Duration(drawers)
+	room.relayTimer = room.state.clock.NewTicker(interval)
+}
+
+func (room *Room) stopRelayTimer() {
+	if room.relayTimer != nil {
+		room.relayTimer.Stop()
+		room.relayTimer = nil
+	}
+}
+
+func (room *Room) relayTimeout() <-chan time.Time {
+	if room.relayTimer == nil {
+		return nil
+	}
+	return room.relayTimer.C()
+}
+
+// receives when the turn runs out of time, a nil channel never receives when there is no turn in progress
+func (room *Room) turnTimeout() <-chan time.Time {
+	if room.turnTimer == nil {
+		return nil
+	}
+	return room.turnTimer.C()
 }
 
 type ErrorMsg struct {
 	ErrorDesc string `json:"errorDesc"`
 }
 
-func sendErrorMsg(ch chan []byte, errorDesc string) {
+func CreateErrorResponse(errorDesc string) ([]byte, error) {
 	e := ErrorMsg{ErrorDesc: errorDesc}
-	buf, err := createResponse[ErrorMsg](ErrorCode, e)
+	frame, err := createResponse[ErrorMsg](ErrorCode, e)
+	return frame.Text, err
+}
+
+func sendErrorMsg(ch chan pubsub.Message, errorDesc string) {
+	resp, err := CreateErrorResponse(errorDesc)
+	if err != nil {
+		log.Println("Failed to serialize error for ws message")
+		return
+	}
+	ch <- pubsub.Message{Data: resp}
+}
+
+// each encoding subscribers can negotiate for broadcasts, every one of them has its own topic
+var topicVariants = []Protocol{{}, {Binary: true}, {Batching: true}, {Binary: true, Batching: true}}
+
+// the broadcast encoding of the protocol, subscribers with the same one share a topic
+func topicVariant(protocol Protocol) Protocol {
+	return Protocol{Binary: protocol.Binary, Batching: protocol.Batching}
+}
+
+// the encodings of the topics with subscribers, publishing every encoding would cost a publish to the pubsub for each
+// one whether anyone receives it or not
+func (room *Room) liveVariants() []Protocol {
+	variants := make([]Protocol, 0, len(topicVariants))
+	for _, variant := range topicVariants {
+		if room.variants[variant] > 0 {
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+// the topic a subscriber with the protocol receives the room's broadcasts on, only the broadcast encoding matters
+func RoomTopic(code string, protocol Protocol) string {
+	format := "json"
+	if protocol.Binary {
+		format = "binary"
+	}
+	if protocol.Batching {
+		format += "+batch"
+	}
+	return "room:" + code + ":" + format
+}
+
+// the topic a single subscriber receives the frames sent only to it on, these go through the pubsub like broadcasts
+// so a subscriber receives them in the order they were sent in
+func SubscriberTopic(code string, id string) string {
+	return "room:" + code + ":subscriber:" + id
+}
+
+func (room *Room) publish(protocol Protocol, msg pubsub.Message) {
+	room.publishTo(RoomTopic(room.state.code, protocol), msg)
+}
+
+func (room *Room) publishTo(topic string, msg pubsub.Message) {
+	err := room.pubsub.Publish(topic, msg)
+	if err != nil {
+		log.Printf("Failed to publish to room %s: %v", room.state.code, err)
+	}
+}
+
+// sends the frame to a single subscriber in the format the subscriber negotiated
+func (room *Room) sendTo(subMsg SubscriberMsg, frame Frame) {
+	room.publishTo(SubscriberTopic(room.state.code, subMsg.ID), frame.Encode(subMsg.Protocol))
+}
+
+// sends the error to a subscriber of the room, a subscriber that never joined is sent it directly
+func (room *Room) sendError(subscriber chan pubsub.Message, errorDesc string) {
+	subMsg, ok := room.subscribers[subscriber]
+	if !ok {
+		sendErrorMsg(subscriber, errorDesc)
+		return
+	}
+	resp, err := CreateErrorResponse(errorDesc)
 	if err != nil {
 		log.Println("Failed to serialize error for ws message")
 		return
 	}
-	ch <- buf
+	room.publishTo(SubscriberTopic(room.state.code, subMsg.ID), pubsub.Message{Data: resp})
+}
+
+// sends the frame to each subscriber in the format the subscriber negotiated
+func (room *Room) broadcast(frame Frame) {
+	if frame.Views != nil {
+		room.sendViews(frame)
+		return
+	}
+	for _, variant := range room.liveVariants() {
+		room.publish(variant, frame.Encode(variant))
+	}
+}
+
+// sends the frame only to the subscribers of the player, such as the words only the drawer may see
+func (room *Room) sendToPlayer(playerID uuid.UUID, frame Frame) {
+	for _, subMsg := range room.subscribers {
+		// a spectator with the id of a player is only shown what every spectator sees
+		if subMsg.Player.ID == playerID && !subMsg.Spectator {
+			room.sendTo(subMsg, frame)
+		}
+	}
+}
+
+// queues the frame for the player, so the player receives it after the message being handled is broadcast
+func (room *Room) sendLater(playerID uuid.UUID, frame Frame) {
+	room.outbox = append(room.outbox, PlayerFrame{PlayerID: playerID, Frame: frame})
+}
+
+func (room *Room) flushOutbox() {
+	for _, playerFrame := range room.outbox {
+		room.sendToPlayer(playerFrame.PlayerID, playerFrame.Frame)
+	}
+	room.outbox = room.outbox[:0]
+}
+
+// sends each subscriber the view of the frame for its player, the room topics can't carry a different message for
+// each subscriber so these frames are published on the topic of each subscriber
+func (room *Room) sendViews(frame Frame) {
+	for _, subMsg := range room.subscribers {
+		view, ok := frame.Views[subMsg.Player.ID]
+		if !ok || subMsg.Spectator {
+			view = frame
+		}
+		room.sendTo(subMsg, view)
+	}
+}
+
+// sends draws to each subscriber: batching subscribers receive one batch, now or on the next flush if the room
+// coalesces draws, other subscribers receive one draw message per circle right away
+func (room *Room) broadcastDraws(circles []Circle, traceID string) error {
+	coalesce := room.state.settings.CoalesceMs > 0
+	if coalesce {
+		room.pending = append(room.pending, circles...)
+	}
+
+	draws := make([]Frame, len(circles))
+	for i, circle := range circles {
+		frame, err := createDrawResponse(circle, traceID)
+		if err != nil {
+			return err
+		}
+		draws[i] = frame
+	}
+
+	batch := draws[0]
+	if len(circles) > 1 {
+		frame, err := createDrawBatchResponse(circles)
+		if err != nil {
+			return err
+		}
+		batch = frame
+	}
+
+	for _, variant := range room.liveVariants() {
+		if !variant.Batching {
+			for _, frame := range draws {
+				room.publish(variant, frame.Encode(variant))
+			}
+		} else if !coalesce {
+			room.publish(variant, batch.Encode(variant))
+		}
+	}
+	return nil
+}
+
+// sends the draws accumulated since the last flush to the batching subscribers
+func (room *Room) flushDraws() {
+	if len(room.pending) == 0 {
+		return
+	}
+
+	frame, err := createDrawBatchResponse(room.pending)
+	room.pending = room.pending[:0]
+	if err != nil {
+		log.Println("Failed to serialize draw batch for ws message")
+		return
+	}
+
+	for _, variant := range room.liveVariants() {
+		if variant.Batching {
+			room.publish(variant, frame.Encode(variant))
+		}
+	}
 }
 
 func (room *Room) onSubscribe(subMsg SubscriberMsg) {
+	if subMsg.Spectator {
+		room.onSpectate(subMsg)
+		return
+	}
+	room.postponeExpiration()
+
+	// pending draws are already on the canvas the new subscriber receives, so they must not be sent to it again
+	room.flushDraws()
+
 	resp, err := room.HandleJoin(subMsg.Player)
 	if err != nil {
 		log.Printf("User %v could not subscribe to the room", subMsg.Player)
@@ -155,96 +533,367 @@ func (room *Room) onSubscribe(subMsg SubscriberMsg) {
 	}
 	log.Printf("User %v subscribed to the room", subMsg.Player)
 
-	room.subscribers[subMsg.Subscriber] = subMsg.Player
+	subMsg = room.subscribe(subMsg)
 
-	for s := range room.subscribers {
-		s <- resp
+	room.broadcast(resp)
+
+	// the joining player was put on a team
+	teamsResp, err := createTeamsResponse(&room.state, "")
+	if err != nil {
+		log.Println("Failed to serialize teams for ws message")
+	} else if !teamsResp.IsEmpty() {
+		room.broadcast(teamsResp)
 	}
 
 	// handle the initial message for the room only send to the subscriber
-	resp, err = room.HandleState()
+	stateResp, err := room.HandleState(subMsg.Protocol, subMsg.Player)
 	if err != nil {
 		// only the sender should receive the error response
-		sendErrorMsg(subMsg.Subscriber, err.Error())
-		close(subMsg.Subscriber)
+		room.sendError(subMsg.Subscriber, err.Error())
+		room.unsubscribe(subMsg.Subscriber)
 		return
 	}
-	subMsg.Subscriber <- resp
+	room.sendTo(subMsg, stateResp)
 }
 
-func (room *Room) onUnsubscribe(subscriber chan []byte) {
-	player := room.subscribers[subscriber]
+// tracks the subscriber and subscribes its channel to its topics, unless the node that accepted the socket did
+func (room *Room) subscribe(subMsg SubscriberMsg) SubscriberMsg {
+	if subMsg.ID == "" {
+		subMsg.ID = uuid.NewString()
+	}
+	room.subscribers[subMsg.Subscriber] = subMsg
+	room.variants[topicVariant(subMsg.Protocol)] += 1
+	if subMsg.Remote {
+		return subMsg
+	}
+	for _, topic := range subMsg.Topics(room.state.code) {
+		if err := room.pubsub.Subscribe(topic, subMsg.Subscriber); err != nil {
+			log.Printf("Failed to subscribe to room %s: %v", room.state.code, err)
+		}
+	}
+	return subMsg
+}
+
+func (room *Room) onUnsubscribe(subscriber chan pubsub.Message) {
+	// a subscriber that failed to join was already closed, nothing can be sent to it
+	subMsg, ok := room.subscribers[subscriber]
+	if !ok {
+		return
+	}
+	if subMsg.Spectator {
+		room.unsubscribe(subscriber)
+		room.broadcastSpectators()
+		return
+	}
+	player := subMsg.Player
+	host := room.state.host
 
 	resp, err := HandleLeave(&room.state, player)
 	if err != nil {
 		// only the sender should receive the error response
-		sendErrorMsg(subscriber, err.Error())
+		room.sendError(subscriber, err.Error())
 		return
 	}
 
-	delete(room.subscribers, subscriber)
-	close(subscriber)
+	room.unsubscribe(subscriber)
 
-	for s := range room.subscribers {
-		s <- resp
+	room.broadcast(resp)
+
+	// the host migrates to another player when the host leaves, even if the host's turn ends below
+	if room.state.host != host {
+		hostResp, err := createHostResponse(&room.state, "")
+		if err != nil {
+			log.Println("Failed to serialize host for ws message")
+		} else if !hostResp.IsEmpty() {
+			room.broadcast(hostResp)
+		}
 	}
 
+	// a telephone turn goes on without its describer, the drawer is given the word instead
+	if room.state.stage == Playing && room.state.turn.describing && room.state.isDescriber(player) {
+		log.Printf("Describer left the room %s, giving the word to the drawer", room.state.code)
+		room.onDescribeTimeout()
+		return
+	}
+	// the turn can't go on without its drawer, so it ends early instead of running out the clock unless another
+	// drawer of the relay can take the canvas
+	if room.state.stage == Playing && room.state.GetCurrPlayer().ID == player.ID {
+		if room.relayTimer != nil && room.passCanvas() {
+			return
+		}
+		log.Printf("Drawer left the room %s, ending the turn early", room.state.code)
+		room.onResetState()
+		return
+	}
+	// the player who left may have been the last one still guessing
+	room.endTurnIfGuessed()
+
 	log.Println("User unsubscribed from the room")
 }
 
 func (room *Room) onMessage(sentMsg SentMsg) {
+	if room.subscribers[sentMsg.Sender].Spectator {
+		room.onSpectatorMessage(sentMsg)
+		return
+	}
+	// the host can only extend a room that is about to expire, so extending mustn't postpone the expiration first
+	if room.state.stage == Lobby && !isExtendMessage(sentMsg) {
+		room.postponeExpiration()
+	}
+
 	// handle the message and get a response, then handle the error case
-	player := room.subscribers[sentMsg.Sender]
-	resp, err := room.HandleMessage(sentMsg.Message, player)
+	player := room.subscribers[sentMsg.Sender].Player
+	var resp Frame
+	var err error
+	if sentMsg.Binary {
+		resp, err = room.handleBinaryMessage(sentMsg.Message, player)
+	} else {
+		resp, err = room.HandleMessage(sentMsg.Message, player)
+	}
 	if err != nil {
 		// only the sender should receive the error response
-		sendErrorMsg(sentMsg.Sender, err.Error())
+		room.sendError(sentMsg.Sender, err.Error())
 		return
 	}
 	// broadcast a non error response to all subscribers
-	if resp != nil {
-		for s := range room.subscribers {
-			s <- resp
+	if !resp.IsEmpty() {
+		room.broadcast(resp)
+	}
+	room.flushOutbox()
+	room.endTurnIfGuessed()
+}
+
+// spectators don't join the game, so they don't count against the player limit or keep the room from expiring
+func (room *Room) onSpectate(subMsg SubscriberMsg) {
+	if room.countSpectators() >= MaxSpectators {
+		sendErrorMsg(subMsg.Subscriber, "Room has too many spectators")
+		close(subMsg.Subscriber)
+		return
+	}
+
+	// pending draws are already on the canvas the spectator receives, so they must not be sent to it again
+	room.flushDraws()
+
+	subMsg = room.subscribe(subMsg)
+	log.Printf("User %v is spectating the room", subMsg.Player)
+
+	// spectators see the state like a player who is still guessing the word
+	stateResp, err := room.HandleState(subMsg.Protocol, Player{})
+	if err != nil {
+		room.sendError(subMsg.Subscriber, err.Error())
+		room.unsubscribe(subMsg.Subscriber)
+		return
+	}
+	room.sendTo(subMsg, stateResp)
+
+	room.broadcastSpectators()
+}
+
+// spectators can only chat with each other
+func (room *Room) onSpectatorMessage(sentMsg SentMsg) {
+	if sentMsg.Binary {
+		room.sendError(sentMsg.Sender, "Spectators can only chat")
+		return
+	}
+	spectator := room.subscribers[sentMsg.Sender].Player
+	resp, err := room.HandleSpectatorMessage(sentMsg.Message, spectator)
+	if err != nil {
+		room.sendError(sentMsg.Sender, err.Error())
+		return
+	}
+	for _, subMsg := range room.subscribers {
+		if subMsg.Spectator {
+			room.sendTo(subMsg, resp)
+		}
+	}
+}
+
+func (room *Room) countSpectators() int {
+	count := 0
+	for _, subMsg := range room.subscribers {
+		if subMsg.Spectator {
+			count++
 		}
 	}
+	return count
+}
+
+func (room *Room) broadcastSpectators() {
+	resp, err := createResponse(SpectatorsCode, SpectatorsMsg{Count: room.countSpectators()})
+	if err != nil {
+		log.Println("Failed to serialize spectators for ws message")
+		return
+	}
+	room.broadcast(resp)
+}
+
+// ends the turn early once there is nobody left to guess the word
+func (room *Room) endTurnIfGuessed() {
+	if room.state.stage == Playing && room.state.AllGuessed() {
+		log.Printf("Every player in room %s guessed the word, ending the turn early", room.state.code)
+		room.onResetState()
+	}
 }
 
 func (room *Room) onResetState() {
+	// the game may have already been finished early since the timer was started
+	if room.state.stage != Playing {
+		return
+	}
+	room.stopTurnTimer()
+
+	// draws from the finished turn must reach subscribers before the canvas is cleared
+	room.flushDraws()
+
 	// reset the game and get a response, then handle the error case
 	resp, err := room.HandleReset()
 	if err != nil {
 		// if an error does exist, serialize it and replace the success message with it
 		e := ErrorMsg{ErrorDesc: err.Error()}
-		buf, err := createResponse[ErrorMsg](ErrorCode, e)
+		frame, err := createResponse[ErrorMsg](ErrorCode, e)
 		if err != nil {
 			log.Println("Failed to serialize error for ws message")
 			return
 		}
-		resp = buf
+		resp = frame
 	}
 	// broadcast the response to all subscribers - error or not
-	for s := range room.subscribers {
-		s <- resp
-	}
+	room.broadcast(resp)
+	room.flushOutbox()
 	// check to handle the shutdown task
 	if !room.state.HasMoreRounds() {
 		room.handler.DoShutdown(room.state.CreateGameResults())
 	}
 }
 
+// the turn timer runs out either while the drawer is choosing the word, while the describer is describing it or while
+// the others are guessing it
+func (room *Room) onTurnTimeout() {
+	if room.state.stage == Playing && room.state.turn.describing {
+		room.onDescribeTimeout()
+		return
+	}
+	if room.state.stage != Playing || !room.state.turn.choosing {
+		room.onResetState()
+		return
+	}
+
+	resp, err := room.HandleChoiceTimeout()
+	if err != nil {
+		log.Printf("Failed to pick a word for room %s: %v", room.state.code, err)
+		return
+	}
+	room.broadcast(resp)
+}
+
+func (room *Room) onDescribeTimeout() {
+	resp, err := room.HandleDescribeTimeout()
+	if err != nil {
+		log.Printf("Failed to give the word to the drawer for room %s: %v", room.state.code, err)
+		return
+	}
+	room.broadcast(resp)
+	room.flushOutbox()
+}
+
+// hands the canvas to the next drawer of the relay, the relay stops once the last drawer holds the canvas
+func (room *Room) onRelay() {
+	if !room.passCanvas() {
+		room.stopRelayTimer()
+	}
+}
+
+// returns false if there was no drawer left to hand the canvas to
+func (room *Room) passCanvas() bool {
+	// draws from the last drawer must reach subscribers before the next drawer's
+	room.flushDraws()
+
+	resp, err := room.HandleRelay()
+	if err != nil {
+		log.Println("Failed to serialize relay for ws message")
+		return false
+	}
+	if resp.IsEmpty() {
+		return false
+	}
+	room.broadcast(resp)
+	if room.state.turn.leg == len(room.state.turn.drawers)-1 {
+		room.stopRelayTimer()
+	}
+	return true
+}
+
+// sends the next hint to the players still guessing the word, the players who can see the word don't need it
+func (room *Room) onHint() {
+	resp, err := room.HandleHint()
+	if err != nil {
+		log.Println("Failed to serialize hint for ws message")
+		return
+	}
+	if resp.IsEmpty() || len(room.state.turn.revealed) >= room.state.settings.HintCount {
+		room.stopHintTimer()
+	}
+	if resp.IsEmpty() {
+		return
+	}
+	for _, player := range room.state.Players() {
+		if !room.state.CanSeeWord(player) {
+			room.sendToPlayer(player.ID, resp)
+		}
+	}
+}
+
+func (room *Room) onGameTimeout() {
+	room.stopTurnTimer()
+	room.flushDraws()
+
+	resp, err := room.HandleGameTimeout()
+	if err != nil {
+		log.Println("Failed to serialize finish for ws message")
+	} else {
+		room.broadcast(resp)
+	}
+	room.handler.DoShutdown(room.state.CreateGameResults())
+}
+
 func (room *Room) onTerminate(code int) {
+	room.stopTurnTimer()
+
 	payload := OutputPayload[struct{}]{Code: code}
 	resp, err := json.Marshal(payload)
 	if err != nil {
 		log.Println("Failed to serialize error for ws message")
 		return
 	}
-	for s := range room.subscribers {
-		s <- resp
+	room.broadcast(Frame{Text: resp})
+	// a game interrupted by a shutdown still counts, so its results and canvas are handed off before the room dies
+	if code == ShutdownCode && room.state.stage == Playing {
+		room.handler.DoInterrupt(room.state.CreateInterruptedResults(), room.state.Capture(room.state.GetCurrPlayer()))
 	}
 	// delete each subscriber from table and close channel
 	for s := range room.subscribers {
-		delete(room.subscribers, s)
-		close(s)
+		room.unsubscribe(s)
 	}
 }
+
+// removes the subscriber from the room and its topics before closing the channel, so nothing is published to it after,
+// the node that subscribed a remote subscriber unsubscribes it once the closed channel ends its connection
+func (room *Room) unsubscribe(subscriber chan pubsub.Message) {
+	subMsg, ok := room.subscribers[subscriber]
+	if ok {
+		variant := topicVariant(subMsg.Protocol)
+		room.variants[variant] -= 1
+		if room.variants[variant] <= 0 {
+			delete(room.variants, variant)
+		}
+	}
+	if !subMsg.Remote {
+		for _, topic := range subMsg.Topics(room.state.code) {
+			if err := room.pubsub.Unsubscribe(topic, subscriber); err != nil {
+				log.Printf("Failed to unsubscribe from room %s: %v", room.state.code, err)
+			}
+		}
+	}
+	delete(room.subscribers, subscriber)
+	close(subscriber)
+}
@@ -5,8 +5,12 @@
 package game
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"guessthesketch/pubsub"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -19,6 +23,8 @@ func (fake FakeHandler) DoShutdown(_ []GameResult) {}
 
 func (fake FakeHandler) DoCapture(_ Snapshot) {}
 
+func (fake FakeHandler) DoInterrupt(_ []GameResult, _ Snapshot) {}
+
 func (fake FakeHandler) OnTermination() {}
 
 // testing the message multiplexing and synchronization works as expected
@@ -42,7 +48,7 @@ func TestRoom(t *testing.T) {
 			ID:   uuid.New(),
 			Name: fmt.Sprintf("Player %d", i),
 		}
-		subscriber := make(chan []byte)
+		subscriber := make(chan pubsub.Message, 16)
 		room.Join(SubscriberMsg{Subscriber: subscriber, Player: p})
 
 		go func(i int) {
@@ -66,3 +72,951 @@ func TestRoom(t *testing.T) {
 
 	room.Stop(0)
 }
+
+// testing a subscriber on another node receives broadcasts by subscribing to the room's topic
+func TestRoom_PublishToOtherNode(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub()
+
+	initialState := NewGameState("123", MockSettings())
+	room := NewRoomWithPubSub(initialState, true, FakeHandler{}, ps)
+	go room.Start()
+	defer room.Stop(0)
+
+	remote := make(chan pubsub.Message, 8)
+	_ = ps.Subscribe(RoomTopic("123", Protocol{}), remote)
+
+	local := make(chan pubsub.Message, 8)
+	player := Player{ID: uuid.New(), Name: "Player"}
+	room.Join(SubscriberMsg{Subscriber: local, Player: player})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"Hello 123"}}`), Sender: local})
+
+	// the remote subscriber receives the join and chat broadcasts but not the state sent only to the joiner
+	for _, expCode := range []int{JoinCode, ChatCode} {
+		select {
+		case msg := <-remote:
+			var payload OutputPayload[json.RawMessage]
+			if err := json.Unmarshal(msg.Data, &payload); err != nil || payload.Code != expCode {
+				t.Fatalf("Expected remote subscriber to receive code %d, got %s", expCode, string(msg.Data))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Remote subscriber didn't receive code %d", expCode)
+		}
+	}
+}
+
+// testing a subscriber whose node subscribed its channel receives every frame through the pubsub in order
+func TestRoom_RemoteSubscriber(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub()
+
+	initialState := NewGameState("123", MockSettings())
+	room := NewRoomWithPubSub(initialState, true, FakeHandler{}, ps)
+	go room.Start()
+
+	// the owner only tracks the forwarded subscriber, frames reach the channel on the node that accepted the socket
+	forwarded := make(chan pubsub.Message, 8)
+	subMsg := SubscriberMsg{Subscriber: forwarded, Player: Player{ID: uuid.New(), Name: "Player"}, ID: "remote", Remote: true}
+	remote := make(chan pubsub.Message, 8)
+	for _, topic := range subMsg.Topics("123") {
+		_ = ps.Subscribe(topic, remote)
+	}
+
+	room.Join(subMsg)
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"Hello 123"}}`), Sender: forwarded})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":-1}`), Sender: forwarded})
+
+	// the frames sent only to the subscriber are ordered with the broadcasts
+	for _, expCode := range []int{JoinCode, StateCode, ChatCode, ErrorCode} {
+		var payload OutputPayload[json.RawMessage]
+		if err := json.Unmarshal(receiveMsg(t, remote), &payload); err != nil || payload.Code != expCode {
+			t.Fatalf("Expected remote subscriber to receive code %d, got %d", expCode, payload.Code)
+		}
+	}
+
+	room.Stop(0)
+	if msg, ok := <-forwarded; ok {
+		t.Fatalf("Expected nothing to be sent to the forwarded channel, got %s", string(msg.Data))
+	}
+}
+
+// testing frames carry the encoding they were sent in, rather than it being guessed from their first byte
+func TestRoom_FrameEncoding(t *testing.T) {
+	initialState := NewGameState("123", MockSettings())
+	initialState.StartGame()
+	room := NewRoom(initialState, true, FakeHandler{})
+	go room.Start()
+	defer room.Stop(0)
+
+	subscriber := make(chan pubsub.Message, 8)
+	player := Player{ID: uuid.New(), Name: "Player"}
+	room.Join(SubscriberMsg{Subscriber: subscriber, Player: player, Protocol: Protocol{Version: 2, Binary: true}})
+
+	if msg := <-subscriber; msg.Binary {
+		t.Fatalf("Expected the join to be sent as text, got %v", msg.Data)
+	}
+	if msg := <-subscriber; !msg.Binary || msg.Data[0] != StateCode {
+		t.Fatalf("Expected the state to be sent as binary, got %v", msg.Data)
+	}
+
+	// packed layout: code, color, radius, x (little endian), y (little endian), connected
+	draw := []byte{DrawCode, 1, 2, 34, 0, 47, 0, 1}
+	room.SendMessage(SentMsg{Message: draw, Binary: true, Sender: subscriber})
+	if msg := <-subscriber; !msg.Binary || !slices.Equal(msg.Data, draw) {
+		t.Fatalf("Expected the binary draw to be sent back as binary, got %v", msg.Data)
+	}
+
+	// a text frame is always json, even if it doesn't look like json
+	room.SendMessage(SentMsg{Message: draw, Sender: subscriber})
+	var payload OutputPayload[ErrorMsg]
+	if err := json.Unmarshal(receiveMsg(t, subscriber), &payload); err != nil || payload.Code != ErrorCode {
+		t.Fatalf("Expected a text frame that isn't json to be rejected, got code %d", payload.Code)
+	}
+}
+
+// records the topics messages are published on
+type RecordingPubSub struct {
+	*pubsub.MemoryPubSub
+	topics map[string]int
+	mu     sync.Mutex
+}
+
+func (ps *RecordingPubSub) Publish(topic string, msg pubsub.Message) error {
+	ps.mu.Lock()
+	ps.topics[topic] += 1
+	ps.mu.Unlock()
+	return ps.MemoryPubSub.Publish(topic, msg)
+}
+
+// testing broadcasts are only published in the encodings subscribers negotiated
+func TestRoom_PublishLiveVariants(t *testing.T) {
+	initialState := NewGameState("123", MockSettings())
+	initialState.StartGame()
+	ps := &RecordingPubSub{MemoryPubSub: pubsub.NewMemoryPubSub(), topics: make(map[string]int)}
+	room := NewRoomWithPubSub(initialState, true, FakeHandler{}, ps)
+	go room.Start()
+
+	subscriber := make(chan pubsub.Message, 8)
+	room.Join(SubscriberMsg{Subscriber: subscriber, Player: Player{ID: uuid.New()}})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":3,"msg":{"color":1,"radius":2,"x":34,"y":47}}`), Sender: subscriber})
+	room.Stop(0)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.topics[RoomTopic("123", Protocol{})] == 0 {
+		t.Fatalf("Expected broadcasts to be published for the json subscriber, got %v", ps.topics)
+	}
+	for _, variant := range []Protocol{{Binary: true}, {Batching: true}, {Binary: true, Batching: true}} {
+		if count := ps.topics[RoomTopic("123", variant)]; count != 0 {
+			t.Fatalf("Expected nothing to be published for %+v without subscribers, got %d", variant, count)
+		}
+	}
+}
+
+// records the results of interrupted games
+type InterruptHandler struct {
+	FakeHandler
+	results chan []GameResult
+}
+
+func (handler InterruptHandler) DoInterrupt(results []GameResult, _ Snapshot) {
+	handler.results <- results
+}
+
+// testing a game interrupted by a shutdown tells its players and hands its results off
+func TestRoom_StopForShutdown(t *testing.T) {
+	initialState := NewGameState("123", MockSettings())
+	initialState.stage = Playing
+	handler := InterruptHandler{results: make(chan []GameResult, 1)}
+	room := NewRoom(initialState, true, handler)
+	go room.Start()
+
+	subscriber := make(chan pubsub.Message, 8)
+	player := Player{ID: uuid.New(), Name: "Player"}
+	room.Join(SubscriberMsg{Subscriber: subscriber, Player: player})
+
+	room.Stop(ShutdownCode)
+
+	var lastCode int
+	for msg := range subscriber {
+		var payload OutputPayload[json.RawMessage]
+		if err := json.Unmarshal(msg.Data, &payload); err == nil {
+			lastCode = payload.Code
+		}
+	}
+	if lastCode != ShutdownCode {
+		t.Fatalf("Expected the last message to have the shutdown code, got %d", lastCode)
+	}
+
+	select {
+	case results := <-handler.results:
+		if len(results) != 1 || results[0].PlayerID != player.ID.String() {
+			t.Fatalf("Expected results for the player, got %v", results)
+		}
+		if results[0].Win {
+			t.Fatalf("Expected nobody to win an interrupted game")
+		}
+	default:
+		t.Fatalf("Expected the interrupted game results to be handed off")
+	}
+
+	// the room is gone, so joining it again must not block
+	late := make(chan pubsub.Message)
+	room.Join(SubscriberMsg{Subscriber: late, Player: player})
+	if _, ok := <-late; ok {
+		t.Fatalf("Expected a subscriber joining a terminated room to be closed")
+	}
+}
+
+func readCode(t *testing.T, ch chan pubsub.Message) int {
+	select {
+	case msg := <-ch:
+		var payload OutputPayload[json.RawMessage]
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			t.Fatalf("Failed to unmarshal %s", string(msg.Data))
+		}
+		return payload.Code
+	default:
+		return 0
+	}
+}
+
+// testing the room warns before expiring, can be extended by the host, then expires
+func TestRoom_Expiration(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithPubSub(NewGameState("123", MockSettings()), true, FakeHandler{}, ps)
+
+	host := Player{ID: uuid.New(), Name: "Host"}
+	_ = room.state.Join(host)
+
+	// broadcasts are only published to topics with subscribers of the room
+	ch := make(chan pubsub.Message, 8)
+	room.subscribe(SubscriberMsg{Subscriber: ch})
+
+	now := time.Now()
+	expireTime := room.expireTime.Load()
+
+	// the host can't extend a room that isn't about to expire
+	if _, err := room.HandleMessage([]byte(`{"code":18}`), host); err == nil {
+		t.Fatalf("Expected extending a room far from expiring to fail")
+	}
+
+	warnTime := time.Unix(expireTime-ExpiryWarningSecs, 0)
+	if room.checkExpiration(warnTime) || readCode(t, ch) != ExpiryCode {
+		t.Fatalf("Expected an expiry warning %d seconds before the room expires", ExpiryWarningSecs)
+	}
+	if room.checkExpiration(warnTime) || readCode(t, ch) != 0 {
+		t.Fatalf("Expected subscribers to be warned only once")
+	}
+
+	room.expireTime.Store(now.Unix() + 10)
+	if _, err := room.HandleMessage([]byte(`{"code":18}`), Player{ID: uuid.New()}); err == nil {
+		t.Fatalf("Expected extending the room to fail for a player who isn't the host")
+	}
+	resp, err := room.HandleMessage([]byte(`{"code":18}`), host)
+	if err != nil {
+		t.Fatalf("Expected the host to extend the room, got %v", err)
+	}
+	var payload OutputPayload[ExpiryMsg]
+	if err = json.Unmarshal(resp.Text, &payload); err != nil || payload.Code != ExpiryCode {
+		t.Fatalf("Expected an expiry message after extending, got %s", string(resp.Text))
+	}
+	expExpireTime := now.Unix() + 10 + int64(room.state.settings.IdleTimeoutSecs)
+	if payload.Msg.ExpireTime != expExpireTime {
+		t.Fatalf("Expected the room to expire at %d, got %d", expExpireTime, payload.Msg.ExpireTime)
+	}
+
+	if !room.checkExpiration(time.Unix(expExpireTime, 0)) {
+		t.Fatalf("Expected the room to expire at its expire time")
+	}
+}
+
+// testing the host can extend an idle room in the lobby once warned it is about to expire
+func TestRoom_ExtendInLobby(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	room := NewRoomWithClock(NewGameState("123", MockSettings()), true, FakeHandler{}, pubsub.NewMemoryPubSub(), clock)
+	go room.Start()
+	defer room.Stop(0)
+
+	host := make(chan pubsub.Message, 8)
+	receiveCode := func() int {
+		var payload OutputPayload[json.RawMessage]
+		_ = json.Unmarshal(receiveMsg(t, host), &payload)
+		return payload.Code
+	}
+	room.Join(SubscriberMsg{Subscriber: host, Player: Player{ID: uuid.New(), Name: "Host"}})
+	for _, expCode := range []int{JoinCode, StateCode} {
+		if code := receiveCode(); code != expCode {
+			t.Fatalf("Expected code %d for the host, got %d", expCode, code)
+		}
+	}
+
+	idleSecs := int64(room.state.settings.IdleTimeoutSecs)
+	clock.Advance(time.Duration(idleSecs-ExpiryWarningSecs) * time.Second)
+	if code := receiveCode(); code != ExpiryCode {
+		t.Fatalf("Expected an expiry warning, got code %d", code)
+	}
+
+	// the extend is sent like any other message, it must not postpone the expiration before it is handled
+	room.SendMessage(SentMsg{Message: []byte(`{"code":18}`), Sender: host})
+	var payload OutputPayload[ExpiryMsg]
+	if err := json.Unmarshal(receiveMsg(t, host), &payload); err != nil || payload.Code != ExpiryCode {
+		t.Fatalf("Expected the host to extend the room, got code %d", payload.Code)
+	}
+	if expExpireTime := 1000 + 2*idleSecs; payload.Msg.ExpireTime != expExpireTime {
+		t.Fatalf("Expected the room to expire at %d, got %d", expExpireTime, payload.Msg.ExpireTime)
+	}
+}
+
+// testing a game running past its max length is finished instead of the room expiring
+func TestRoom_GameTimeout(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithPubSub(NewGameState("123", MockSettings()), true, FakeHandler{}, ps)
+	_ = room.state.Join(Player{ID: uuid.New(), Name: "Host"})
+	room.state.StartGame()
+	room.setExpiration(room.state.settings.MaxGameSecs)
+
+	ch := make(chan pubsub.Message, 8)
+	room.subscribe(SubscriberMsg{Subscriber: ch})
+
+	if room.checkExpiration(time.Unix(room.expireTime.Load(), 0)) {
+		t.Fatalf("Expected the room to stay open after the game times out")
+	}
+	if room.state.stage != Post || readCode(t, ch) != FinishCode {
+		t.Fatalf("Expected the game to be finished after timing out")
+	}
+	remainingSecs := room.expireTime.Load() - time.Now().Unix()
+	if remainingSecs < int64(room.state.settings.PostStageSecs)-1 {
+		t.Fatalf("Expected the room to stay open for the post game stage, expires in %d", remainingSecs)
+	}
+}
+
+// testing a finished game restarts once a majority of players vote for a rematch
+func TestRoom_RematchVote(t *testing.T) {
+	room := NewRoom(NewGameState("123", MockSettings()), true, FakeHandler{})
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = room.state.Join(player)
+	}
+	room.state.StartGame()
+	room.state.FinishGame()
+
+	// only the host can change the settings of the rematch
+	if _, err := room.HandleMessage([]byte(`{"code":19,"msg":{"settings":{}}}`), players[1]); err == nil {
+		t.Fatalf("Expected a player who isn't the host to be unable to change the settings")
+	}
+
+	type TestRematch struct {
+		player  Player
+		expCode int
+	}
+	tests := []TestRematch{
+		{player: players[1], expCode: VoteCode},
+		{player: players[2], expCode: RestartCode},
+	}
+	for i, test := range tests {
+		resp, err := room.HandleMessage([]byte(`{"code":19,"msg":{}}`), test.player)
+		if err != nil {
+			t.Fatalf("Failed to handle rematch %d: %v", i, err)
+		}
+		var payload OutputPayload[json.RawMessage]
+		if err = json.Unmarshal(resp.Text, &payload); err != nil || payload.Code != test.expCode {
+			t.Fatalf("Expected code %d for rematch %d, got %s", test.expCode, i, string(resp.Text))
+		}
+	}
+	if room.state.stage != Playing || len(room.state.matches) != 1 {
+		t.Fatalf("Expected the rematch to start a new game")
+	}
+}
+
+// testing the host is broadcast to subscribers when the host leaves the room
+func TestRoom_HostLeaves(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithPubSub(NewGameState("123", MockSettings()), true, FakeHandler{}, ps)
+	go room.Start()
+	defer room.Stop(0)
+
+	remote := make(chan pubsub.Message, 8)
+	_ = ps.Subscribe(RoomTopic("123", Protocol{}), remote)
+
+	host := Player{ID: uuid.New(), Name: "Host"}
+	player := Player{ID: uuid.New(), Name: "Player"}
+	hostSub := make(chan pubsub.Message, 8)
+	playerSub := make(chan pubsub.Message, 8)
+	room.Join(SubscriberMsg{Subscriber: hostSub, Player: host})
+	room.Join(SubscriberMsg{Subscriber: playerSub, Player: player})
+	room.Leave(hostSub)
+
+	for _, expCode := range []int{JoinCode, JoinCode, LeaveCode, HostCode} {
+		select {
+		case msg := <-remote:
+			var payload OutputPayload[HostMsg]
+			if err := json.Unmarshal(msg.Data, &payload); err != nil || payload.Code != expCode {
+				t.Fatalf("Expected code %d, got %s", expCode, string(msg.Data))
+			}
+			if expCode == HostCode && payload.Msg.Player.ID != player.ID {
+				t.Fatalf("Expected the host to migrate to the remaining player")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Didn't receive code %d", expCode)
+		}
+	}
+
+	// the host still migrates when the host leaving is the drawer, whose turn ends early
+	drawerRoom := NewRoom(NewGameState("456", MockSettings()), true, FakeHandler{})
+	go drawerRoom.Start()
+	defer drawerRoom.Stop(0)
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	subscribers := make([]chan pubsub.Message, len(players))
+	for i, player := range players {
+		subscribers[i] = make(chan pubsub.Message, 16)
+		drawerRoom.Join(SubscriberMsg{Subscriber: subscribers[i], Player: player})
+	}
+
+	// the second player draws the first turn and is given the host
+	drawerRoom.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: subscribers[0]})
+	transfer := `{"code":23,"msg":{"playerId":"` + players[1].ID.String() + `"}}`
+	drawerRoom.SendMessage(SentMsg{Message: []byte(transfer), Sender: subscribers[0]})
+	drawerRoom.Leave(subscribers[1])
+
+	for {
+		var payload OutputPayload[json.RawMessage]
+		if err := json.Unmarshal(receiveMsg(t, subscribers[2]), &payload); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if payload.Code == LeaveCode {
+			break
+		}
+	}
+	var payload OutputPayload[HostMsg]
+	if err := json.Unmarshal(receiveMsg(t, subscribers[2]), &payload); err != nil || payload.Code != HostCode {
+		t.Fatalf("Expected the host to migrate when the drawer leaves, got code %d", payload.Code)
+	}
+	if payload.Msg.Player.ID != players[0].ID {
+		t.Fatalf("Expected the host to migrate to the player present the longest")
+	}
+	var finish OutputPayload[json.RawMessage]
+	if err := json.Unmarshal(receiveMsg(t, subscribers[2]), &finish); err != nil || finish.Code != FinishCode {
+		t.Fatalf("Expected the turn of the drawer to end after the host migrates, got code %d", finish.Code)
+	}
+}
+
+// testing the turn ends early and reveals the word when the drawer leaves
+func TestRoom_DrawerLeaves(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithPubSub(NewGameState("123", MockSettings()), true, FakeHandler{}, ps)
+	go room.Start()
+	defer room.Stop(0)
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	subscribers := make([]chan pubsub.Message, len(players))
+	for i, player := range players {
+		subscribers[i] = make(chan pubsub.Message, 16)
+		room.Join(SubscriberMsg{Subscriber: subscribers[i], Player: player})
+	}
+
+	// the second player draws the first turn
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: subscribers[0]})
+	room.Leave(subscribers[1])
+
+	// turn messages are sent to each player rather than published, so they are read from a player's subscriber
+	for _, expCode := range []int{JoinCode, StateCode, JoinCode, JoinCode, BeginCode, LeaveCode, FinishCode} {
+		select {
+		case msg := <-subscribers[0]:
+			var payload OutputPayload[FinishMsg]
+			if err := json.Unmarshal(msg.Data, &payload); err != nil || payload.Code != expCode {
+				t.Fatalf("Expected code %d, got %s", expCode, string(msg.Data))
+			}
+			if expCode == FinishCode {
+				if payload.Msg.Word == "" || payload.Msg.BeginMsg == nil || payload.Msg.BeginMsg.NextPlayerIndex != 2 {
+					t.Fatalf("Expected the word to be revealed and the next turn to go to the third player, got %s", string(msg.Data))
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Didn't receive code %d", expCode)
+		}
+	}
+}
+
+// testing the turn ends as soon as every player guessed the word
+func TestRoom_AllGuessed(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"word"}
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithPubSub(NewGameState("123", settings), true, FakeHandler{}, ps)
+	go room.Start()
+	defer room.Stop(0)
+
+	host := make(chan pubsub.Message, 16)
+	drawer := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: host, Player: Player{ID: uuid.New()}})
+	room.Join(SubscriberMsg{Subscriber: drawer, Player: Player{ID: uuid.New()}})
+
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: host})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"is it word"}}`), Sender: host})
+
+	// the guesser is sent the word once it guessed it
+	for _, expCode := range []int{JoinCode, StateCode, JoinCode, BeginCode, ChatCode, WordCode, FinishCode} {
+		select {
+		case msg := <-host:
+			var payload OutputPayload[WordMsg]
+			if err := json.Unmarshal(msg.Data, &payload); err != nil || payload.Code != expCode {
+				t.Fatalf("Expected code %d, got %s", expCode, string(msg.Data))
+			}
+			if expCode == WordCode && payload.Msg.Word != "word" {
+				t.Fatalf("Expected the guesser to be sent the word, got %s", string(msg.Data))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Didn't receive code %d", expCode)
+		}
+	}
+}
+
+// testing the turn ends once its deadline passes on the room's clock
+func TestRoom_TurnTimer(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithClock(NewGameState("123", MockSettings()), true, FakeHandler{}, ps, clock)
+	go room.Start()
+	defer room.Stop(0)
+
+	host := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: host, Player: Player{ID: uuid.New()}})
+	room.Join(SubscriberMsg{Subscriber: make(chan pubsub.Message, 16), Player: Player{ID: uuid.New()}})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: host})
+
+	receive := func(wait time.Duration) *OutputPayload[json.RawMessage] {
+		select {
+		case msg := <-host:
+			var payload OutputPayload[json.RawMessage]
+			if err := json.Unmarshal(msg.Data, &payload); err != nil {
+				t.Fatalf("Failed to unmarshal %s", string(msg.Data))
+			}
+			return &payload
+		case <-time.After(wait):
+			return nil
+		}
+	}
+
+	for _, expCode := range []int{JoinCode, StateCode, JoinCode, BeginCode} {
+		payload := receive(time.Second)
+		if payload == nil || payload.Code != expCode {
+			t.Fatalf("Expected code %d, got %v", expCode, payload)
+		}
+		if expCode == BeginCode {
+			var msg BeginMsg
+			_ = json.Unmarshal(payload.Msg, &msg)
+			expDeadline := time.Unix(1000+int64(room.state.settings.TimeLimitSecs), 0).UnixMilli()
+			if msg.Deadline != expDeadline {
+				t.Fatalf("Expected the turn deadline to be %d, got %d", expDeadline, msg.Deadline)
+			}
+		}
+	}
+
+	limit := time.Duration(room.state.settings.TimeLimitSecs) * time.Second
+	clock.Advance(limit - time.Second)
+	if payload := receive(50 * time.Millisecond); payload != nil {
+		t.Fatalf("Expected the turn to continue before its deadline, got code %d", payload.Code)
+	}
+	clock.Advance(time.Second)
+	if payload := receive(time.Second); payload == nil || payload.Code != FinishCode {
+		t.Fatalf("Expected the turn to finish at its deadline, got %v", payload)
+	}
+}
+
+// testing the drawer is privately sent the candidates and a word is picked for it once the time to choose runs out
+func TestRoom_ChoiceTimeout(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"Word1", "Word2", "Word3"}
+	settings.WordChoices = 3
+
+	clock := NewFakeClock(time.Unix(1000, 0))
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithClock(NewGameState("123", settings), true, FakeHandler{}, ps, clock)
+	go room.Start()
+	defer room.Stop(0)
+
+	remote := make(chan pubsub.Message, 16)
+	_ = ps.Subscribe(RoomTopic("123", Protocol{}), remote)
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}}
+	subscribers := []chan pubsub.Message{make(chan pubsub.Message, 16), make(chan pubsub.Message, 16)}
+	for i, player := range players {
+		room.Join(SubscriberMsg{Subscriber: subscribers[i], Player: player})
+	}
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: subscribers[0]})
+
+	for _, expCode := range []int{JoinCode, JoinCode, ChooseCode} {
+		var payload OutputPayload[json.RawMessage]
+		_ = json.Unmarshal(receiveMsg(t, remote), &payload)
+		if payload.Code != expCode {
+			t.Fatalf("Expected code %d, got %d", expCode, payload.Code)
+		}
+	}
+
+	clock.Advance(time.Duration(settings.ChoiceTimeSecs) * time.Second)
+
+	// reads each message the subscriber was sent until the turn begins
+	receiveTurn := func(subscriber chan pubsub.Message) (*WordsMsg, BeginMsg) {
+		var words *WordsMsg
+		for {
+			buf := receiveMsg(t, subscriber)
+			var payload OutputPayload[json.RawMessage]
+			_ = json.Unmarshal(buf, &payload)
+			switch payload.Code {
+			case WordsCode:
+				words = &WordsMsg{}
+				_ = json.Unmarshal(payload.Msg, words)
+			case BeginCode:
+				var msg BeginMsg
+				_ = json.Unmarshal(payload.Msg, &msg)
+				return words, msg
+			}
+		}
+	}
+
+	// the second player draws the first turn, only the drawer receives the candidates and the picked word
+	words, beginMsg := receiveTurn(subscribers[1])
+	if words == nil || !slices.Contains(words.Words, beginMsg.NextWord) {
+		t.Fatalf("Expected the drawer to be sent the word %s picked from its candidates %v", beginMsg.NextWord, words)
+	}
+	expDeadline := time.Unix(1000+int64(settings.ChoiceTimeSecs+settings.TimeLimitSecs), 0).UnixMilli()
+	if beginMsg.Deadline != expDeadline {
+		t.Fatalf("Expected the turn deadline to be %d, got %d", expDeadline, beginMsg.Deadline)
+	}
+
+	words, beginMsg = receiveTurn(subscribers[0])
+	if words != nil || beginMsg.NextWord != "_____" {
+		t.Fatalf("Expected the guesser to only be sent the masked word, got %s and %v", beginMsg.NextWord, words)
+	}
+}
+
+// testing hints are revealed over the turn to the players still guessing the word
+func TestRoom_Hints(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"quick"}
+	settings.HintCount = 2
+
+	clock := NewFakeClock(time.Unix(1000, 0))
+	room := NewRoomWithClock(NewGameState("123", settings), true, FakeHandler{}, pubsub.NewMemoryPubSub(), clock)
+	go room.Start()
+	defer room.Stop(0)
+
+	guesser := make(chan pubsub.Message, 16)
+	drawer := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: guesser, Player: Player{ID: uuid.New()}})
+	room.Join(SubscriberMsg{Subscriber: drawer, Player: Player{ID: uuid.New()}})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: guesser})
+
+	// reads messages from the subscriber until one with the code arrives, returning the hints received until then
+	receiveUntil := func(subscriber chan pubsub.Message, code int) []string {
+		var patterns []string
+		for {
+			var payload OutputPayload[HintMsg]
+			_ = json.Unmarshal(receiveMsg(t, subscriber), &payload)
+			if payload.Code == HintCode {
+				patterns = append(patterns, payload.Msg.Pattern)
+			}
+			if payload.Code == code {
+				return patterns
+			}
+		}
+	}
+	receiveUntil(guesser, BeginCode)
+
+	interval := time.Duration(settings.TimeLimitSecs) * time.Second / 3
+	for i := 1; i <= 2; i++ {
+		clock.Advance(interval)
+		patterns := receiveUntil(guesser, HintCode)
+		if hidden := strings.Count(patterns[0], "_"); len(patterns) != 1 || hidden != 5-i {
+			t.Fatalf("Expected hint %d to reveal %d letters, got %v", i, i, patterns)
+		}
+	}
+
+	clock.Advance(interval)
+	if patterns := receiveUntil(drawer, FinishCode); len(patterns) != 0 {
+		t.Fatalf("Expected the drawer to not be sent hints, got %v", patterns)
+	}
+}
+
+// testing a close guess is only sent back to the guesser instead of being broadcast as chat
+func TestRoom_CloseGuess(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"elephant"}
+	ps := pubsub.NewMemoryPubSub()
+	room := NewRoomWithPubSub(NewGameState("123", settings), true, FakeHandler{}, ps)
+	go room.Start()
+	defer room.Stop(0)
+
+	guesser := make(chan pubsub.Message, 16)
+	drawer := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: guesser, Player: Player{ID: uuid.New()}})
+	room.Join(SubscriberMsg{Subscriber: drawer, Player: Player{ID: uuid.New()}})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: guesser})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"elefant"}}`), Sender: guesser})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"hello there"}}`), Sender: guesser})
+	// an ordinary sentence is broadcast even if one of its words is near the word
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"is it an elefant"}}`), Sender: guesser})
+
+	expCodes := map[chan pubsub.Message][]int{
+		guesser: {JoinCode, StateCode, JoinCode, BeginCode, CloseCode, ChatCode, ChatCode},
+		drawer:  {JoinCode, StateCode, BeginCode, ChatCode, ChatCode},
+	}
+	for subscriber, codes := range expCodes {
+		for _, expCode := range codes {
+			var payload OutputPayload[Chat]
+			buf := receiveMsg(t, subscriber)
+			_ = json.Unmarshal(buf, &payload)
+			if payload.Code != expCode {
+				t.Fatalf("Expected code %d, got %s", expCode, string(buf))
+			}
+			if expCode == ChatCode && payload.Msg.Text == "elefant" {
+				t.Fatalf("Expected the close guess to not be broadcast, got %s", string(buf))
+			}
+		}
+	}
+}
+
+// testing players who can see the word chat in a channel the players still guessing don't receive
+func TestRoom_GuessedChannel(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"quick"}
+	room := NewRoom(NewGameState("123", settings), true, FakeHandler{})
+	go room.Start()
+	defer room.Stop(0)
+
+	// the second player draws the first turn
+	subscribers := []chan pubsub.Message{make(chan pubsub.Message, 16), make(chan pubsub.Message, 16), make(chan pubsub.Message, 16)}
+	for _, subscriber := range subscribers {
+		room.Join(SubscriberMsg{Subscriber: subscriber, Player: Player{ID: uuid.New()}})
+	}
+	guessed, drawer, guessing := subscribers[0], subscribers[1], subscribers[2]
+
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: guessed})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"is it quick"}}`), Sender: guessed})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"that was easy"}}`), Sender: guessed})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"it is QUICK"}}`), Sender: drawer})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"no idea yet"}}`), Sender: guessing})
+
+	type TestChannel struct {
+		subscriber chan pubsub.Message
+		expCodes   []int
+		expChats   []string
+	}
+	tests := []TestChannel{
+		{
+			subscriber: drawer,
+			expCodes:   []int{JoinCode, StateCode, JoinCode, BeginCode, ChatCode, ChatCode, ErrorCode, ChatCode},
+			expChats:   []string{"", "that was easy", "no idea yet"},
+		},
+		{
+			subscriber: guessing,
+			expCodes:   []int{JoinCode, StateCode, BeginCode, ChatCode, ChatCode},
+			expChats:   []string{"", "no idea yet"},
+		},
+	}
+	for i, test := range tests {
+		var chats []string
+		for _, expCode := range test.expCodes {
+			var payload OutputPayload[Chat]
+			buf := receiveMsg(t, test.subscriber)
+			_ = json.Unmarshal(buf, &payload)
+			if payload.Code != expCode {
+				t.Fatalf("Expected code %d for subscriber %d, got %s", expCode, i, string(buf))
+			}
+			if expCode == ChatCode {
+				chats = append(chats, payload.Msg.Text)
+			}
+		}
+		if !slices.Equal(chats, test.expChats) {
+			t.Fatalf("Expected chats %v for subscriber %d, got %v", test.expChats, i, chats)
+		}
+	}
+}
+
+// testing a spectator joining a full room is turned away without taking the room down when it leaves
+func TestRoom_SpectatorLimit(t *testing.T) {
+	room := NewRoom(NewGameState("123", MockSettings()), true, FakeHandler{})
+	go room.Start()
+	defer room.Stop(0)
+
+	for i := 0; i < MaxSpectators; i++ {
+		room.Join(SubscriberMsg{Subscriber: make(chan pubsub.Message, 128), Player: Player{ID: uuid.New()}, Spectator: true})
+	}
+	rejected := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: rejected, Player: Player{ID: uuid.New()}, Spectator: true})
+
+	var payload OutputPayload[ErrorMsg]
+	_ = json.Unmarshal(receiveMsg(t, rejected), &payload)
+	if payload.Code != ErrorCode {
+		t.Fatalf("Expected the spectator past the limit to receive an error, got %d", payload.Code)
+	}
+	if _, ok := <-rejected; ok {
+		t.Fatalf("Expected the channel of the rejected spectator to be closed")
+	}
+	room.Leave(rejected)
+
+	// the room keeps running for the players who join it after
+	player := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: player, Player: Player{ID: uuid.New()}})
+	_ = json.Unmarshal(receiveMsg(t, player), &payload)
+	if payload.Code != JoinCode {
+		t.Fatalf("Expected the room to keep accepting players, got %d", payload.Code)
+	}
+}
+
+func TestRoom_Spectator(t *testing.T) {
+	settings := MockSettings()
+	settings.PlayerLimit = 2
+	settings.SpectatorChat = true
+	settings.SharedWordBank = []string{"quick"}
+	room := NewRoom(NewGameState("123", settings), true, FakeHandler{})
+	go room.Start()
+	defer room.Stop(0)
+
+	// the spectator doesn't count against the player limit
+	players := []chan pubsub.Message{make(chan pubsub.Message, 16), make(chan pubsub.Message, 16)}
+	for _, player := range players {
+		room.Join(SubscriberMsg{Subscriber: player, Player: Player{ID: uuid.New()}})
+	}
+	spectator := make(chan pubsub.Message, 16)
+	room.Join(SubscriberMsg{Subscriber: spectator, Player: Player{ID: uuid.New()}, Spectator: true})
+
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: players[0]})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"is it quick"}}`), Sender: spectator})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: spectator})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"no idea yet"}}`), Sender: players[0]})
+
+	expCodes := []int{StateCode, SpectatorsCode, BeginCode, SpectatorChatCode, ErrorCode, ChatCode}
+	for _, expCode := range expCodes {
+		var payload OutputPayload[json.RawMessage]
+		buf := receiveMsg(t, spectator)
+		_ = json.Unmarshal(buf, &payload)
+		if payload.Code != expCode {
+			t.Fatalf("Expected code %d for spectator, got %s", expCode, string(buf))
+		}
+		if expCode == BeginCode {
+			var begin BeginMsg
+			_ = json.Unmarshal(payload.Msg, &begin)
+			if begin.NextWord == "quick" {
+				t.Fatalf("Expected the word to be hidden from the spectator")
+			}
+		}
+	}
+
+	// the players never receive the spectator chat and are still the only players in the game
+	expCodes = []int{JoinCode, StateCode, SpectatorsCode, BeginCode, ChatCode}
+	for _, expCode := range expCodes {
+		var payload OutputPayload[json.RawMessage]
+		buf := receiveMsg(t, players[1])
+		_ = json.Unmarshal(buf, &payload)
+		if payload.Code != expCode {
+			t.Fatalf("Expected code %d for player, got %s", expCode, string(buf))
+		}
+	}
+}
+
+func TestRoom_Telephone(t *testing.T) {
+	room := NewRoom(NewGameState("123", MockModeSettings(TelephoneMode)), true, FakeHandler{})
+	go room.Start()
+	defer room.Stop(0)
+
+	// the second player describes the word for the third player to draw
+	players := []chan pubsub.Message{make(chan pubsub.Message, 16), make(chan pubsub.Message, 16), make(chan pubsub.Message, 16)}
+	for _, player := range players {
+		room.Join(SubscriberMsg{Subscriber: player, Player: Player{ID: uuid.New()}})
+	}
+	guesser, describer, drawer := players[0], players[1], players[2]
+
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: guesser})
+	// the describer can't say the word while describing it, and the rest of their chat stays in the guessed channel
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"it is quick"}}`), Sender: describer})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"this is easy"}}`), Sender: describer})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":35,"msg":{"text":"the opposite of slow"}}`), Sender: describer})
+	room.SendMessage(SentMsg{Message: []byte(`{"code":2,"msg":{"text":"slow opposite"}}`), Sender: drawer})
+
+	type TestChannel struct {
+		subscriber chan pubsub.Message
+		expCodes   []int
+	}
+	tests := []TestChannel{
+		{subscriber: describer, expCodes: []int{JoinCode, StateCode, JoinCode, ChooseCode, WordCode, ErrorCode, ChatCode, BeginCode}},
+		{subscriber: drawer, expCodes: []int{JoinCode, StateCode, ChooseCode, BeginCode, DescriptionCode, ErrorCode}},
+		{subscriber: guesser, expCodes: []int{JoinCode, StateCode, JoinCode, JoinCode, ChooseCode, BeginCode}},
+	}
+	for i, test := range tests {
+		for _, expCode := range test.expCodes {
+			var payload OutputPayload[json.RawMessage]
+			buf := receiveMsg(t, test.subscriber)
+			_ = json.Unmarshal(buf, &payload)
+			if payload.Code != expCode {
+				t.Fatalf("Expected code %d for subscriber %d, got %s", expCode, i, string(buf))
+			}
+			// only the describer is sent the word
+			if payload.Code == BeginCode {
+				var begin BeginMsg
+				_ = json.Unmarshal(payload.Msg, &begin)
+				if (begin.NextWord == "quick") != (test.subscriber == describer) {
+					t.Fatalf("Expected only the describer to see the word, subscriber %d got %s", i, begin.NextWord)
+				}
+			}
+		}
+	}
+
+	// the guesser never receives the describer's chat
+	select {
+	case msg := <-guesser:
+		t.Fatalf("Expected the describer's chat to be hidden from the guesser, got %s", string(msg.Data))
+	default:
+	}
+}
+
+func TestRoom_Relay(t *testing.T) {
+	settings := MockModeSettings(RelayMode)
+	clock := NewFakeClock(time.Unix(1000, 0))
+	room := NewRoomWithClock(NewGameState("123", settings), true, FakeHandler{}, pubsub.NewMemoryPubSub(), clock)
+	go room.Start()
+	defer room.Stop(0)
+
+	players := []chan pubsub.Message{make(chan pubsub.Message, 16), make(chan pubsub.Message, 16), make(chan pubsub.Message, 16), make(chan pubsub.Message, 16)}
+	for _, player := range players {
+		room.Join(SubscriberMsg{Subscriber: player, Player: Player{ID: uuid.New()}})
+	}
+	room.SendMessage(SentMsg{Message: []byte(`{"code":1}`), Sender: players[0]})
+
+	// reads messages from the subscriber until one with the code arrives
+	receiveUntil := func(code int) []byte {
+		for {
+			var payload OutputPayload[json.RawMessage]
+			_ = json.Unmarshal(receiveMsg(t, players[0]), &payload)
+			if payload.Code == code {
+				return payload.Msg
+			}
+		}
+	}
+	receiveUntil(BeginCode)
+
+	// the canvas is passed along the relay after each share of the time limit, then the turn ends
+	interval := time.Duration(settings.TimeLimitSecs) * time.Second / RelayDrawers
+	for _, expIndex := range []int{2, 3} {
+		clock.Advance(interval)
+		var relay RelayMsg
+		_ = json.Unmarshal(receiveUntil(RelayCode), &relay)
+		if relay.NextPlayerIndex != expIndex {
+			t.Fatalf("Expected the canvas to be passed to player %d, got %d", expIndex, relay.NextPlayerIndex)
+		}
+	}
+	clock.Advance(interval)
+	receiveUntil(FinishCode)
+}
+
+// waits for the next message on the channel, failing the test if none arrives
+func receiveMsg(t *testing.T, ch chan pubsub.Message) []byte {
+	select {
+	case msg := <-ch:
+		return msg.Data
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message to be received")
+		return nil
+	}
+}
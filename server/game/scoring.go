@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DecayScoringPolicy = "decay"
+	FlatScoringPolicy  = "flat"
+
+	MaxGuessPoints  = 400 // a guess made right as the turn begins
+	MinGuessPoints  = 50  // a guess made right before the time runs out
+	MaxDrawPoints   = 300 // the drawer's points when every player guessed the word
+	FlatGuessPoints = 100
+	FlatDrawPoints  = 50 // the drawer's points for each player who guessed the word
+)
+
+// the bonus for the first guessers of a turn, in the order they guessed
+var orderBonuses = []int{100, 50, 25}
+
+// the points for the first guessers of a speed turn, in the order they guessed
+var speedPoints = []int{300, 200, 100}
+
+// a correct guess the guesser is scored for
+type GuessContext struct {
+	Elapsed   time.Duration // time since the drawing began
+	TimeLimit time.Duration
+	Order     int // players who guessed the word before, 0 for the first guesser
+}
+
+// a finished turn the drawer is scored for
+type DrawContext struct {
+	Guessers int // players who guessed the word
+	Eligible int // players who could have guessed the word
+	Drawers  int // players who drew the word together
+}
+
+// decides the points for guessing and drawing, each room picks a policy in its settings
+type ScoringPolicy interface {
+	GuessPoints(guess GuessContext) int
+	DrawPoints(draw DrawContext) int
+}
+
+var scoringPolicies = map[string]ScoringPolicy{
+	DecayScoringPolicy: DecayScoring{},
+	FlatScoringPolicy:  FlatScoring{},
+}
+
+// guesses are worth less the longer the turn went on, with a bonus for guessing first, and the drawer is rewarded
+// for the share of players who guessed the word
+type DecayScoring struct{}
+
+func (policy DecayScoring) GuessPoints(guess GuessContext) int {
+	remaining := 0.0
+	if guess.TimeLimit > 0 {
+		remaining = 1 - float64(guess.Elapsed)/float64(guess.TimeLimit)
+	}
+	remaining = math.Max(0, math.Min(1, remaining))
+
+	points := MinGuessPoints + int(math.Round(remaining*(MaxGuessPoints-MinGuessPoints)))
+	if guess.Order >= 0 && guess.Order < len(orderBonuses) {
+		points += orderBonuses[guess.Order]
+	}
+	return points
+}
+
+func (policy DecayScoring) DrawPoints(draw DrawContext) int {
+	if draw.Eligible <= 0 {
+		return 0
+	}
+	share := math.Min(1, float64(draw.Guessers)/float64(draw.Eligible))
+	return int(math.Round(share * MaxDrawPoints))
+}
+
+// every guess is worth the same and the drawer gets the same points for each player who guessed the word
+type FlatScoring struct{}
+
+func (policy FlatScoring) GuessPoints(_ GuessContext) int {
+	return FlatGuessPoints
+}
+
+func (policy FlatScoring) DrawPoints(draw DrawContext) int {
+	return draw.Guessers * FlatDrawPoints
+}
+
+// only the first guessers are rewarded, in the order they guessed, while the drawer is scored like the decay policy
+type SpeedScoring struct {
+	DecayScoring
+}
+
+func (policy SpeedScoring) GuessPoints(guess GuessContext) int {
+	if guess.Order >= 0 && guess.Order < len(speedPoints) {
+		return speedPoints[guess.Order]
+	}
+	return MinGuessPoints
+}
+
+// splits the points for a drawing evenly between the players who drew it
+type SharedScoring struct {
+	Policy ScoringPolicy
+}
+
+func (policy SharedScoring) GuessPoints(guess GuessContext) int {
+	return policy.Policy.GuessPoints(guess)
+}
+
+func (policy SharedScoring) DrawPoints(draw DrawContext) int {
+	return policy.Policy.DrawPoints(draw) / max(1, draw.Drawers)
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScoringPolicy_GuessPoints(t *testing.T) {
+	limit := 60 * time.Second
+
+	type TestGuess struct {
+		policy    ScoringPolicy
+		guess     GuessContext
+		expPoints int
+	}
+	tests := []TestGuess{
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 0, TimeLimit: limit, Order: 0}, expPoints: 500},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 0, TimeLimit: limit, Order: 1}, expPoints: 450},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 0, TimeLimit: limit, Order: 3}, expPoints: 400},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 15 * time.Second, TimeLimit: limit, Order: 3}, expPoints: 313},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 30 * time.Second, TimeLimit: limit, Order: 3}, expPoints: 225},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 31 * time.Second, TimeLimit: limit, Order: 3}, expPoints: 219},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: limit, TimeLimit: limit, Order: 3}, expPoints: 50},
+		{policy: DecayScoring{}, guess: GuessContext{Elapsed: 2 * limit, TimeLimit: limit, Order: 3}, expPoints: 50},
+		{policy: FlatScoring{}, guess: GuessContext{Elapsed: 0, TimeLimit: limit, Order: 0}, expPoints: 100},
+		{policy: FlatScoring{}, guess: GuessContext{Elapsed: limit, TimeLimit: limit, Order: 3}, expPoints: 100},
+		{policy: SpeedScoring{}, guess: GuessContext{Elapsed: limit, TimeLimit: limit, Order: 0}, expPoints: 300},
+		{policy: SpeedScoring{}, guess: GuessContext{Elapsed: 0, TimeLimit: limit, Order: 2}, expPoints: 100},
+		{policy: SpeedScoring{}, guess: GuessContext{Elapsed: 0, TimeLimit: limit, Order: 3}, expPoints: 50},
+		{policy: SharedScoring{Policy: FlatScoring{}}, guess: GuessContext{Elapsed: 0, TimeLimit: limit}, expPoints: 100},
+	}
+	for i, test := range tests {
+		if points := test.policy.GuessPoints(test.guess); points != test.expPoints {
+			t.Fatalf("Expected guess %d to score %d points, got %d", i, test.expPoints, points)
+		}
+	}
+}
+
+func TestScoringPolicy_DrawPoints(t *testing.T) {
+	type TestDraw struct {
+		policy    ScoringPolicy
+		draw      DrawContext
+		expPoints int
+	}
+	tests := []TestDraw{
+		{policy: DecayScoring{}, draw: DrawContext{Guessers: 0, Eligible: 4}, expPoints: 0},
+		{policy: DecayScoring{}, draw: DrawContext{Guessers: 1, Eligible: 4}, expPoints: 75},
+		{policy: DecayScoring{}, draw: DrawContext{Guessers: 2, Eligible: 3}, expPoints: 200},
+		{policy: DecayScoring{}, draw: DrawContext{Guessers: 4, Eligible: 4}, expPoints: 300},
+		{policy: DecayScoring{}, draw: DrawContext{Guessers: 2, Eligible: 1}, expPoints: 300},
+		{policy: DecayScoring{}, draw: DrawContext{Guessers: 0, Eligible: 0}, expPoints: 0},
+		{policy: FlatScoring{}, draw: DrawContext{Guessers: 3, Eligible: 4}, expPoints: 150},
+		{policy: SpeedScoring{}, draw: DrawContext{Guessers: 4, Eligible: 4}, expPoints: 300},
+		{policy: SharedScoring{Policy: DecayScoring{}}, draw: DrawContext{Guessers: 4, Eligible: 4, Drawers: 3}, expPoints: 100},
+		{policy: SharedScoring{Policy: FlatScoring{}}, draw: DrawContext{Guessers: 3, Eligible: 3, Drawers: 0}, expPoints: 150},
+	}
+	for i, test := range tests {
+		if points := test.policy.DrawPoints(test.draw); points != test.expPoints {
+			t.Fatalf("Expected drawing %d to score %d points, got %d", i, test.expPoints, points)
+		}
+	}
+}
+
+func TestSettings_ScoringPolicy(t *testing.T) {
+	settings := MockSettings()
+	if settings.Scoring != DecayScoringPolicy {
+		t.Fatalf("Expected the default scoring policy to be %s, got %s", DecayScoringPolicy, settings.Scoring)
+	}
+	settings.Scoring = "unknown"
+	if IsSettingsValid(settings) == nil {
+		t.Fatalf("Expected an unknown scoring policy to be invalid")
+	}
+}
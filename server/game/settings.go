@@ -5,6 +5,7 @@
 package game
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -15,15 +16,45 @@ const (
 	MaxPlayerLimit = 12
 	MinTotalRounds = 1
 	MaxTotalRounds = 8
+	MaxCoalesceMs  = 100
+
+	MinIdleTimeout = 2 * 60
+	MaxIdleTimeout = 60 * 60
+	MinGameLength  = 5 * 60
+	MaxGameLength  = 4 * 60 * 60
+	MinPostLength  = 30
+	MaxPostLength  = 30 * 60
+	MinWordChoices = 1
+	MaxWordChoices = 5
+	MinChoiceTime  = 5
+	MaxChoiceTime  = 30
+	MaxHintCount   = 3
+	MinHintPenalty = 5
+	MaxHintPenalty = 25
+	MinTeamCount   = 2
+	MaxTeamCount   = 4
+	MaxSpectators  = 50 // spectators that can watch a room at once, they don't count against the player limit
 )
 
 type RoomSettings struct {
-	PlayerLimit    int      `json:"playerLimit"`    // max players that can join room state
-	TotalRounds    int      `json:"totalRounds"`    // total rounds for the game to go through
-	TimeLimitSecs  int      `json:"timeLimitSecs"`  // time given for guessing each turn
-	CustomWordBank []string `json:"customWordBank"` // custom words added in the bank by host
-	SharedWordBank []string `json:"-"`              // reference to the shared word bank
-	IsPublic       bool     `json:"isPublic"`       // whether the room is publicly accessible of not
+	PlayerLimit     int      `json:"playerLimit"`     // max players that can join room state
+	TotalRounds     int      `json:"totalRounds"`     // total rounds for the game to go through
+	TimeLimitSecs   int      `json:"timeLimitSecs"`   // time given for guessing each turn
+	CustomWordBank  []string `json:"customWordBank"`  // custom words added in the bank by host
+	SharedWordBank  []string `json:"-"`               // reference to the shared word bank
+	IsPublic        bool     `json:"isPublic"`        // whether the room is publicly accessible of not
+	CoalesceMs      int      `json:"coalesceMs"`      // window to accumulate draws for batching clients, 0 sends them immediately
+	IdleTimeoutSecs int      `json:"idleTimeoutSecs"` // time the room waits in the lobby without activity before it expires
+	MaxGameSecs     int      `json:"maxGameSecs"`     // time a game can run before it is finished early
+	PostStageSecs   int      `json:"postStageSecs"`   // time the room stays open after a game finishes
+	WordChoices     int      `json:"wordChoices"`     // words the drawer chooses between each turn, 1 skips choosing
+	ChoiceTimeSecs  int      `json:"choiceTimeSecs"`  // time the drawer has to choose before a word is picked for them
+	HintCount       int      `json:"hintCount"`       // letters revealed to guessers during a turn, 0 gives no hints
+	HintPenalty     int      `json:"hintPenalty"`     // percent of the points a guess loses for each hint revealed before it
+	Scoring         string   `json:"scoring"`         // name of the scoring policy for guesses and drawings
+	TeamCount       int      `json:"teamCount"`       // teams the players are split into, 0 plays without teams
+	SpectatorChat   bool     `json:"spectatorChat"`   // whether spectators can chat with each other
+	Mode            string   `json:"mode"`            // name of the game mode deciding how turns are played and scored
 }
 
 // applies default settings to preexisting settings struct any zero value field
@@ -37,6 +68,30 @@ func SettingsWithDefaults(settings *RoomSettings) {
 	if settings.TotalRounds == 0 {
 		settings.TotalRounds = 3
 	}
+	if settings.IdleTimeoutSecs == 0 {
+		settings.IdleTimeoutSecs = 15 * 60
+	}
+	if settings.MaxGameSecs == 0 {
+		settings.MaxGameSecs = 60 * 60
+	}
+	if settings.PostStageSecs == 0 {
+		settings.PostStageSecs = 5 * 60
+	}
+	if settings.WordChoices == 0 {
+		settings.WordChoices = 3
+	}
+	if settings.ChoiceTimeSecs == 0 {
+		settings.ChoiceTimeSecs = 15
+	}
+	if settings.HintPenalty == 0 {
+		settings.HintPenalty = 20
+	}
+	if settings.Scoring == "" {
+		settings.Scoring = DecayScoringPolicy
+	}
+	if settings.Mode == "" {
+		settings.Mode = ClassicMode
+	}
 	if settings.CustomWordBank == nil {
 		settings.CustomWordBank = make([]string, 0)
 	}
@@ -52,6 +107,46 @@ func IsSettingsValid(settings RoomSettings) error {
 	if settings.TotalRounds > MaxTotalRounds || settings.TotalRounds < MinTotalRounds {
 		return fmt.Errorf("Games can only have between %d and %d rounds", MinTotalRounds, MaxTotalRounds)
 	}
+	if settings.CoalesceMs < 0 || settings.CoalesceMs > MaxCoalesceMs {
+		return fmt.Errorf("Draw coalescing window must be between 0 and %d milliseconds", MaxCoalesceMs)
+	}
+	if settings.IdleTimeoutSecs < MinIdleTimeout || settings.IdleTimeoutSecs > MaxIdleTimeout {
+		return fmt.Errorf("Idle timeout must be between %d and %d seconds", MinIdleTimeout, MaxIdleTimeout)
+	}
+	if settings.MaxGameSecs < MinGameLength || settings.MaxGameSecs > MaxGameLength {
+		return fmt.Errorf("Max game length must be between %d and %d seconds", MinGameLength, MaxGameLength)
+	}
+	if settings.PostStageSecs < MinPostLength || settings.PostStageSecs > MaxPostLength {
+		return fmt.Errorf("Post game length must be between %d and %d seconds", MinPostLength, MaxPostLength)
+	}
+	if settings.WordChoices < MinWordChoices || settings.WordChoices > MaxWordChoices {
+		return fmt.Errorf("Drawers can only choose between %d and %d words", MinWordChoices, MaxWordChoices)
+	}
+	if settings.ChoiceTimeSecs < MinChoiceTime || settings.ChoiceTimeSecs > MaxChoiceTime {
+		return fmt.Errorf("Time to choose a word must be between %d and %d seconds", MinChoiceTime, MaxChoiceTime)
+	}
+	if settings.HintCount < 0 || settings.HintCount > MaxHintCount {
+		return fmt.Errorf("Hints must be between %d and %d", 0, MaxHintCount)
+	}
+	if settings.HintPenalty < MinHintPenalty || settings.HintPenalty > MaxHintPenalty {
+		return fmt.Errorf("Hint penalty must be between %d and %d percent", MinHintPenalty, MaxHintPenalty)
+	}
+	if settings.TeamCount != 0 && (settings.TeamCount < MinTeamCount || settings.TeamCount > MaxTeamCount) {
+		return fmt.Errorf("Team count must be between %d and %d", MinTeamCount, MaxTeamCount)
+	}
+	if settings.TeamCount > settings.PlayerLimit {
+		return errors.New("Player limit must allow a player on every team")
+	}
+	if _, ok := scoringPolicies[settings.Scoring]; !ok {
+		return fmt.Errorf("No scoring policy named %s", settings.Scoring)
+	}
+	if _, ok := gameModes[settings.Mode]; !ok {
+		return fmt.Errorf("No game mode named %s", settings.Mode)
+	}
+	// the drawers of a relay and the describer of a telephone turn aren't picked from a team
+	if settings.TeamCount > 0 && settings.Mode != ClassicMode && settings.Mode != SpeedMode {
+		return errors.New("Teams can only play the classic and speed modes")
+	}
 	return nil
 }
 
@@ -59,5 +154,7 @@ func MockSettings() RoomSettings {
 	var settings RoomSettings
 	SettingsWithDefaults(&settings)
 	settings.SharedWordBank = []string{"Word1", "Word2"}
+	// turns begin right away unless a test is about choosing words
+	settings.WordChoices = 1
 	return settings
 }
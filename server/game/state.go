@@ -5,17 +5,17 @@
 package game
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"log"
 	"math/rand"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 )
 
 const (
@@ -34,6 +34,17 @@ type GameState struct {
 	stage      int                 // the current stage the room is
 	turn       GameTurn            // stores the current game turn
 	settings   RoomSettings        // settings for the room set before game starts
+	matches    []Match             // games previously finished in the room, oldest first
+	votes      map[uuid.UUID]bool  // players who voted for a rematch since the game finished
+	host       uuid.UUID           // ID of the player who can start and configure games
+	joinOrder  map[uuid.UUID]int   // orders present players by when they last joined, earliest first
+	joins      int                 // count of joins, used to order the players
+	clock      Clock               // the source of time for turns
+	teams      map[uuid.UUID]int   // maps player IDs to their team in team mode, players who left keep their team
+	// maps each team to the index of the player who last drew for it
+	teamDrawers map[int]int
+	// players who joined during the current round, they draw from the next round on
+	queued map[uuid.UUID]bool
 }
 
 type GameTurn struct {
@@ -41,7 +52,16 @@ type GameTurn struct {
 	currPlayerIndex int                // index of player drawing on canvas
 	canvas          []Circle           // canvas of circles, acts as a sparse matrix which can be used to construct a bitmap
 	guessers        map[uuid.UUID]bool // map storing each player ID who has guessed correctly this game
-	startTimeSecs   int64              // start time in milliseconds (unix epoch)
+	startTime       time.Time          // when the turn started, or when the drawer started choosing its word
+	choosing        bool               // whether the drawer is still choosing the word from the candidates
+	candidates      []string           // words the drawer chooses from
+	revealed        map[int]bool       // indices of the letters of the word revealed to guessers as hints
+	team            int                // team of the drawer in team mode
+	drawers         []int              // indices of the players drawing the turn, in the order they hold the canvas
+	leg             int                // position in the drawers of the player holding the canvas
+	describer       int                // index of the player describing the word in telephone mode, -1 without one
+	describing      bool               // whether the describer is still describing the word
+	description     string             // what the drawer draws from when the turn has a describer
 }
 
 type StateJson struct {
@@ -51,12 +71,23 @@ type StateJson struct {
 	ChatLog    []Chat              `json:"chatLog"`
 	Stage      int                 `json:"stage"`
 	Turn       TurnJson            `json:"turn"`
+	Matches    []Match             `json:"matches"`
+	Host       *Player             `json:"host"`
+	Teams      map[uuid.UUID]int   `json:"teams"`      // nil unless the room is in team mode
+	TeamScores []int               `json:"teamScores"` // nil unless the room is in team mode
+	Queued     []uuid.UUID         `json:"queued"`     // players who joined during the round and draw from the next one
 }
 
 type TurnJson struct {
-	CurrWord   string  `json:"currWord"`
-	CurrPlayer *Player `json:"currPlayer"`
-	Canvas     string  `json:"canvas"`
+	CurrWord    string   `json:"currWord"`
+	CurrPlayer  *Player  `json:"currPlayer"`
+	Canvas      string   `json:"canvas"`
+	Deadline    int64    `json:"deadline"`    // unix time in milliseconds the turn ends at
+	Choosing    bool     `json:"choosing"`    // whether the drawer is still choosing the word
+	Drawers     []Player `json:"drawers"`     // the players drawing the turn, in the order they hold the canvas
+	Describer   *Player  `json:"describer"`   // the player describing the word, nil unless the turn has a describer
+	Describing  bool     `json:"describing"`  // whether the describer is still describing the word
+	Description string   `json:"description"` // empty unless the viewer is on the turn or can see the word
 }
 
 type Circle struct {
@@ -73,6 +104,12 @@ type Snapshot struct {
 	Canvas    string
 }
 
+// the final scores of a finished game
+type Match struct {
+	Players    []Player            `json:"players"`
+	ScoreBoard map[uuid.UUID]Score `json:"scoreBoard"`
+}
+
 type Score struct {
 	Points   int `json:"points"`
 	words    int
@@ -89,22 +126,31 @@ type Chat struct {
 	Player         Player `json:"player"`
 	Text           string `json:"text"`
 	GuessPointsInc int    `json:"guessPointsInc"` // if this is larger than 0, player guessed correctly
+	Guessed        bool   `json:"guessed"`        // sent in the guessed channel, only players who can see the word see it
 }
 
 func NewGameState(code string, settings RoomSettings) GameState {
 	initialTurn := GameTurn{
 		canvas:          make([]Circle, 0),
 		currPlayerIndex: 0,
-		startTimeSecs:   time.Now().Unix(),
+		startTime:       time.Now(),
 		guessers:        make(map[uuid.UUID]bool),
+		team:            -1,
+		describer:       -1,
 	}
 	return GameState{
-		code:       code,
-		players:    make([]Player, 0),
-		scoreBoard: make(map[uuid.UUID]Score),
-		chatLog:    make([]Chat, 0),
-		settings:   settings,
-		turn:       initialTurn,
+		code:        code,
+		players:     make([]Player, 0),
+		scoreBoard:  make(map[uuid.UUID]Score),
+		chatLog:     make([]Chat, 0),
+		matches:     make([]Match, 0),
+		joinOrder:   make(map[uuid.UUID]int),
+		clock:       RealClock{},
+		settings:    settings,
+		turn:        initialTurn,
+		teams:       make(map[uuid.UUID]int),
+		teamDrawers: make(map[int]int),
+		queued:      make(map[uuid.UUID]bool),
 	}
 }
 
@@ -117,38 +163,80 @@ func (state *GameState) EncodeCanvas() string {
 		return ""
 	}
 
-	var buf bytes.Buffer
-	err := binary.Write(&buf, binary.LittleEndian, state.turn.canvas)
+	buf, err := packCircles(state.turn.canvas)
 	if err != nil {
 		log.Println(err.Error())
 		return ""
 	}
 
-	base64Encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	base64Encoded := base64.StdEncoding.EncodeToString(buf)
 	return base64Encoded
 }
 
-func (state *GameState) MarshalJson() []byte {
-	currIdx := state.turn.currPlayerIndex
+func (state *GameState) toJson(viewer Player, canvas string) StateJson {
+	currIdx := state.drawerIndex()
 	var curr *Player
 	if currIdx >= 0 && currIdx < len(state.players) {
 		curr = &state.players[currIdx]
 	}
+	var describer *Player
+	if state.turn.describer >= 0 {
+		describer = &state.players[state.turn.describer]
+	}
+	drawers := make([]Player, 0, len(state.turn.drawers))
+	for _, index := range state.turn.drawers {
+		drawers = append(drawers, state.players[index])
+	}
 
 	turnJson := TurnJson{
-		CurrWord:   state.turn.currWord,
-		CurrPlayer: curr,
-		Canvas:     state.EncodeCanvas(),
+		CurrWord:    state.wordFor(viewer),
+		CurrPlayer:  curr,
+		Canvas:      canvas,
+		Deadline:    state.TurnDeadline().UnixMilli(),
+		Choosing:    state.turn.choosing,
+		Drawers:     drawers,
+		Describer:   describer,
+		Describing:  state.turn.describing,
+		Description: state.descriptionFor(viewer),
 	}
-	stateJson := StateJson{
+	return StateJson{
 		CurrRound:  state.currRound,
 		Players:    state.Players(),
 		ScoreBoard: state.scoreBoard,
-		ChatLog:    state.chatLog,
+		ChatLog:    state.chatLogFor(viewer),
+		Stage:      state.stage,
 		Turn:       turnJson,
+		Matches:    state.matches,
+		Host:       state.hostPlayer(),
+		Teams:      state.Teams(),
+		TeamScores: state.TeamScores(),
+		Queued:     state.Queued(),
 	}
-	buf, err := json.Marshal(stateJson)
+}
+
+// encodes the canvas as raw bytes, either packed circles or the smaller compressed encoding
+func (state *GameState) canvasBytes(compress bool) ([]byte, error) {
+	if compress {
+		return compressCircles(state.turn.canvas)
+	}
+	return packCircles(state.turn.canvas)
+}
 
+// marshals the state as the viewer sees it, the word is masked unless the viewer can see it
+func (state *GameState) MarshalJson(viewer Player, compress bool) []byte {
+	canvas := ""
+	if compress {
+		buf, err := state.canvasBytes(compress)
+		if err != nil {
+			log.Println(err.Error())
+			return []byte{}
+		}
+		canvas = base64.StdEncoding.EncodeToString(buf)
+	} else {
+		canvas = state.EncodeCanvas()
+	}
+
+	buf, err := json.Marshal(state.toJson(viewer, canvas))
 	if err != nil {
 		log.Println(err.Error())
 		return []byte{}
@@ -156,15 +244,88 @@ func (state *GameState) MarshalJson() []byte {
 	return buf
 }
 
+// encodes the state as a binary state frame, the canvas is appended as raw bytes instead of base64 in the json
+func (state *GameState) EncodeBinary(viewer Player, compress bool) []byte {
+	stateJson, err := json.Marshal(state.toJson(viewer, ""))
+	if err != nil {
+		log.Println(err.Error())
+		return []byte{}
+	}
+	canvas, err := state.canvasBytes(compress)
+	if err != nil {
+		log.Println(err.Error())
+		return []byte{}
+	}
+	return createBinaryState(stateJson, canvas)
+}
+
+// the player holding the canvas
 func (state *GameState) GetCurrPlayer() Player {
-	if state.turn.currPlayerIndex < 0 {
+	index := state.drawerIndex()
+	if index < 0 {
 		return Player{}
 	}
-	return state.players[state.turn.currPlayerIndex]
+	return state.players[index]
+}
+
+// the index of the player holding the canvas, the drawers are only set once the game started
+func (state *GameState) drawerIndex() int {
+	if state.turn.leg < len(state.turn.drawers) {
+		return state.turn.drawers[state.turn.leg]
+	}
+	return state.turn.currPlayerIndex
+}
+
+// whether the player draws or describes the turn, so the player can't guess the word
+func (state *GameState) onTurn(player Player) bool {
+	if state.isDescriber(player) || state.GetCurrPlayer().ID == player.ID {
+		return true
+	}
+	for _, index := range state.turn.drawers {
+		if state.players[index].ID == player.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *GameState) isDescriber(player Player) bool {
+	return state.turn.describer >= 0 && state.players[state.turn.describer].ID == player.ID
 }
 
 func (state *GameState) PlayerIsNotHost(player Player) bool {
-	return len(state.players) < 1 || state.players[0].ID != player.ID
+	return state.host == uuid.Nil || state.host != player.ID
+}
+
+func (state *GameState) hostPlayer() *Player {
+	index := state.playerIndex(Player{ID: state.host})
+	if index < 0 {
+		return nil
+	}
+	return &state.players[index]
+}
+
+// gives the host to the player, who must be present in the room
+func (state *GameState) TransferHost(player Player) error {
+	index := state.playerIndex(player)
+	if index < 0 || !state.players[index].present {
+		return errors.New("Host can only be transferred to a player in the room")
+	}
+	state.host = player.ID
+	return nil
+}
+
+// migrates the host to the player who has been present the longest, or to nobody if the room is empty
+func (state *GameState) migrateHost() {
+	state.host = uuid.Nil
+	earliest := -1
+	for _, player := range state.players {
+		order := state.joinOrder[player.ID]
+		if player.present && (earliest < 0 || order < earliest) {
+			state.host = player.ID
+			earliest = order
+		}
+	}
 }
 
 func (state *GameState) playerIndex(playerToFind Player) int {
@@ -180,11 +341,14 @@ func (state *GameState) playerIndex(playerToFind Player) int {
 }
 
 func (state *GameState) Join(player Player) error {
-	if len(state.players) >= state.settings.PlayerLimit {
+	index := state.playerIndex(player)
+	// only present players take up a place, so players who left don't keep others out and a player joining again
+	// from another socket doesn't need another place
+	alreadyPresent := index >= 0 && state.players[index].present
+	if !alreadyPresent && len(state.Players()) >= state.settings.PlayerLimit {
 		return errors.New("Player cannot join, state is at player limit")
 	}
 
-	index := state.playerIndex(player)
 	if index >= 0 {
 		// player already exists - they are rejoining and we mark as present
 		state.players[index].present = true
@@ -192,6 +356,11 @@ func (state *GameState) Join(player Player) error {
 		// mark the new joined player as present and add it to the end of the players
 		player.present = true
 		state.players = append(state.players, player)
+		// a player joining a game in progress can guess right away, but waits for the next round to draw so the
+		// drawing order of the current round stays the same
+		if state.stage == Playing {
+			state.queued[player.ID] = true
+		}
 	}
 
 	_, exists := state.scoreBoard[player.ID]
@@ -199,6 +368,15 @@ func (state *GameState) Join(player Player) error {
 		state.scoreBoard[player.ID] = Score{}
 	}
 
+	state.assignTeam(player)
+
+	state.joins++
+	state.joinOrder[player.ID] = state.joins
+	// a room without a host present is given to the player joining it
+	if host := state.hostPlayer(); host == nil || !host.present {
+		state.host = player.ID
+	}
+
 	return nil
 }
 
@@ -219,6 +397,10 @@ func (state *GameState) Leave(player Player) int {
 		return -1
 	}
 	state.players[index].present = false
+	delete(state.joinOrder, player.ID)
+	if state.host == player.ID {
+		state.migrateHost()
+	}
 	return index
 }
 
@@ -227,9 +409,19 @@ func (state *GameState) StartGame() {
 	state.stage = Playing
 	state.clearGuessers()
 	state.clearCanvas()
-	state.setNextWord()
-	state.cycleCurrPlayer()
+	state.turn.revealed = make(map[int]bool)
+	state.turn.leg = 0
+	state.turn.describer = -1
+	state.turn.describing = false
+	state.turn.description = ""
+	state.mode().NextTurn(state)
 	state.resetStartTime()
+	// the describer is given a word to describe rather than choosing one
+	if state.settings.WordChoices > 1 && !state.turn.describing {
+		state.setCandidates()
+	} else {
+		state.setNextWord()
+	}
 }
 
 func (state *GameState) clearGuessers() {
@@ -243,36 +435,171 @@ func (state *GameState) clearCanvas() {
 }
 
 func (state *GameState) setNextWord() {
+	state.turn.currWord = state.randomWord()
+	state.turn.choosing = false
+	state.turn.candidates = nil
+}
+
+func (state *GameState) randomWord() string {
 	// pick a new word from the shared or custom word bank
 	numSharedWords := len(state.settings.SharedWordBank)
 	numCustomWords := len(state.settings.CustomWordBank)
 	if numCustomWords < 1 || rand.Intn(2) == 0 {
 		index := rand.Intn(numSharedWords)
-		state.turn.currWord = state.settings.SharedWordBank[index]
+		return state.settings.SharedWordBank[index]
 	} else {
 		index := rand.Intn(numCustomWords)
-		state.turn.currWord = state.settings.CustomWordBank[index]
+		return state.settings.CustomWordBank[index]
 	}
 }
 
+// picks distinct candidates for the drawer to choose from, fewer if the word banks don't have enough words
+func (state *GameState) setCandidates() {
+	count := state.settings.WordChoices
+	candidates := make([]string, 0, count)
+	picked := make(map[string]bool)
+	for attempts := 0; len(candidates) < count && attempts < count*10; attempts++ {
+		word := state.randomWord()
+		if !picked[word] {
+			picked[word] = true
+			candidates = append(candidates, word)
+		}
+	}
+	state.turn.currWord = ""
+	state.turn.choosing = true
+	state.turn.candidates = candidates
+}
+
+// sets the word the drawer chose from the candidates, the drawing time starts once it is chosen
+func (state *GameState) ChooseWord(index int) error {
+	if !state.turn.choosing {
+		return errors.New("The word for this turn was already chosen")
+	}
+	if index < 0 || index >= len(state.turn.candidates) {
+		return errors.New("Word must be one of the candidates")
+	}
+	state.turn.currWord = state.turn.candidates[index]
+	state.turn.choosing = false
+	state.turn.candidates = nil
+	state.resetStartTime()
+	return nil
+}
+
 func (state *GameState) cycleCurrPlayer() {
-	// go to the next player, circle back around when we reach the end
+	if state.IsTeamMode() {
+		state.cycleTeamDrawer()
+		return
+	}
+	// go to the next present player, circle back around when we reach the end
 	turn := &state.turn
-	turn.currPlayerIndex += 1
-	if turn.currPlayerIndex >= len(state.players) {
-		turn.currPlayerIndex = 0
-		state.currRound += 1
+	last := len(state.players) - 1
+	for skipped := 0; ; skipped++ {
+		turn.currPlayerIndex += 1
+		if turn.currPlayerIndex >= len(state.players) {
+			turn.currPlayerIndex = 0
+			// the queued players can draw in the new round, so every player is checked again
+			if len(state.queued) > 0 {
+				last = skipped + len(state.players) - 1
+			}
+			state.nextRound()
+		}
+		// stop once every other player was skipped, so a room without anyone present still moves on
+		if skipped >= last || state.canDraw(turn.currPlayerIndex) {
+			return
+		}
+	}
+}
+
+func (state *GameState) nextRound() {
+	state.currRound += 1
+	state.queued = make(map[uuid.UUID]bool)
+}
+
+// whether the player at the index can take the next turn
+func (state *GameState) canDraw(index int) bool {
+	player := state.players[index]
+	return player.present && !state.queued[player.ID]
+}
+
+// the present players waiting for the next round to draw, in the order they will draw
+func (state *GameState) Queued() []uuid.UUID {
+	queued := make([]uuid.UUID, 0)
+	for _, player := range state.players {
+		if player.present && state.queued[player.ID] {
+			queued = append(queued, player.ID)
+		}
 	}
+	return queued
 }
 
 func (state *GameState) resetStartTime() {
-	state.turn.startTimeSecs = time.Now().Unix()
+	state.turn.startTime = state.clock.Now()
+}
+
+// when the current turn runs out of time
+func (state *GameState) TurnDeadline() time.Time {
+	if state.turn.choosing {
+		return state.turn.startTime.Add(time.Duration(state.settings.ChoiceTimeSecs) * time.Second)
+	}
+	if state.turn.describing {
+		return state.turn.startTime.Add(DescribeTimeSecs * time.Second)
+	}
+	return state.turn.startTime.Add(time.Duration(state.TimeLimit()) * time.Second)
 }
 
 func (state *GameState) FinishGame() {
 	state.stage = Post
 }
 
+// archives the finished game and resets the state for a new game between the players still in the room
+func (state *GameState) Rematch(settings RoomSettings) Match {
+	match := Match{Players: state.players, ScoreBoard: state.scoreBoard}
+	matches := append(state.matches, match)
+	host := state.host
+	clock := state.clock
+	teams := state.teams
+	teamCount := state.settings.TeamCount
+
+	// players join the new game in the order they joined the finished one, so the host migrates the same way
+	players := state.Players()
+	joinOrder := state.joinOrder
+	sort.SliceStable(players, func(i, j int) bool {
+		return joinOrder[players[i].ID] < joinOrder[players[j].ID]
+	})
+
+	*state = NewGameState(state.code, settings)
+	state.clock = clock
+	state.matches = matches
+	// players stay on their teams for a rematch with the same number of teams
+	if settings.TeamCount == teamCount {
+		state.teams = teams
+	}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	if state.playerIndex(Player{ID: host}) >= 0 {
+		state.host = host
+	}
+	return match
+}
+
+// records a player's vote for a rematch, returning the votes of present players and the votes needed for a majority
+func (state *GameState) VoteRematch(player Player) (int, int) {
+	if state.votes == nil {
+		state.votes = make(map[uuid.UUID]bool)
+	}
+	state.votes[player.ID] = true
+
+	players := state.Players()
+	votes := 0
+	for _, p := range players {
+		if state.votes[p.ID] {
+			votes++
+		}
+	}
+	return votes, len(players)/2 + 1
+}
+
 func (state *GameState) TryGuess(player Player, text string) Chat {
 	pointsInc := state.guess(player, text)
 
@@ -288,36 +615,142 @@ func (state *GameState) TryGuess(player Player, text string) Chat {
 
 // handlers a player's guess and returns the increase in the score of player due to the guess
 func (state *GameState) guess(guesser Player, text string) int {
-	if state.stage != Playing {
-		return 0
-	}
-	if guesser.ID == state.GetCurrPlayer().ID {
-		return 0
-	}
-	if !state.containsCurrWord(text) {
-		return 0
-	}
-	if state.turn.guessers[guesser.ID] {
+	if !state.canGuess(guesser) || guessDistance(text, state.turn.currWord) != 0 {
 		return 0
 	}
 
 	// calculate the score increments for successful guess
-	elapsed := time.Now().Unix() - state.turn.startTimeSecs
-	limit := state.settings.TimeLimitSecs
-	pointsInc := (limit-int(elapsed))/limit*400 + 50
+	pointsInc := state.scoring().GuessPoints(GuessContext{
+		Elapsed:   state.clock.Now().Sub(state.turn.startTime),
+		TimeLimit: time.Duration(state.TimeLimit()) * time.Second,
+		Order:     len(state.turn.guessers),
+	})
+	// a guess is worth less for each hint it was given
+	pointsInc -= pointsInc * len(state.turn.revealed) * state.settings.HintPenalty / 100
 	state.incScore(guesser, Score{Points: pointsInc, words: 1})
 
 	state.turn.guessers[guesser.ID] = true
 	return pointsInc
 }
 
-func (state *GameState) containsCurrWord(text string) bool {
-	for _, word := range strings.Split(text, " ") {
-		if strings.ToLower(word) == state.turn.currWord {
-			return true
+// the players on the turn and players who guessed the word can see it, nobody needs to guess it outside of a game.
+// drawers only see the description when the turn has a describer
+func (state *GameState) CanSeeWord(player Player) bool {
+	if state.stage != Playing || state.turn.guessers[player.ID] {
+		return true
+	}
+	if state.turn.describer >= 0 {
+		return state.isDescriber(player)
+	}
+	return state.onTurn(player)
+}
+
+func (state *GameState) wordFor(player Player) string {
+	if state.CanSeeWord(player) {
+		return state.turn.currWord
+	}
+	return state.MaskedWord()
+}
+
+// the word with every letter hidden, so guessers only learn its length and where its spaces are
+func (state *GameState) MaskedWord() string {
+	var sb strings.Builder
+	for i, r := range []rune(state.turn.currWord) {
+		if unicode.IsSpace(r) || state.turn.revealed[i] {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
 		}
 	}
-	return false
+	return sb.String()
+}
+
+// reveals a random hidden letter of the word, a single letter is always left hidden so a hint never gives the word
+// away. returns false if there was no letter to reveal
+func (state *GameState) RevealHint() bool {
+	var hidden []int
+	for i, r := range []rune(state.turn.currWord) {
+		if !unicode.IsSpace(r) && !state.turn.revealed[i] {
+			hidden = append(hidden, i)
+		}
+	}
+	if len(hidden) < 2 {
+		return false
+	}
+	state.turn.revealed[hidden[rand.Intn(len(hidden))]] = true
+	return true
+}
+
+// whether every present player not on the turn has guessed the word, false if there is nobody to guess it
+func (state *GameState) AllGuessed() bool {
+	guessing := 0
+	for _, player := range state.Players() {
+		if state.onTurn(player) {
+			continue
+		}
+		if !state.turn.guessers[player.ID] {
+			return false
+		}
+		guessing++
+	}
+	return guessing > 0
+}
+
+// chat from players who can see the word, it can only be seen by the other players who can see the word. the
+// players on the turn can't say the word even in the guessed channel
+func (state *GameState) GuessedChat(player Player, text string) (Chat, error) {
+	if state.onTurn(player) && guessDistance(text, state.turn.currWord) == 0 {
+		return Chat{}, errors.New("Players on the turn cannot say the word")
+	}
+	chat := Chat{Player: player, Text: text, Guessed: true}
+	state.chatLog = append(state.chatLog, chat)
+	return chat, nil
+}
+
+// whether the player chats in the guessed channel rather than with everyone. the describer already knows the word
+// while describing it, so their chat stays in the guessed channel too
+func (state *GameState) InGuessedChannel(player Player) bool {
+	return state.stage == Playing && !state.turn.choosing && state.CanSeeWord(player)
+}
+
+// whether the player draws from a description, it could give the description away to the guessers in the chat
+func (state *GameState) DrawsDescription(player Player) bool {
+	return state.stage == Playing && state.turn.describer >= 0 && state.onTurn(player) && !state.CanSeeWord(player)
+}
+
+// the chat log without the guessed channel for players who can't see the word
+func (state *GameState) chatLogFor(viewer Player) []Chat {
+	if state.CanSeeWord(viewer) {
+		return state.chatLog
+	}
+	chatLog := make([]Chat, 0, len(state.chatLog))
+	for _, chat := range state.chatLog {
+		if !chat.Guessed {
+			chatLog = append(chatLog, chat)
+		}
+	}
+	return chatLog
+}
+
+// whether the player is still guessing the word of a turn in progress
+func (state *GameState) canGuess(guesser Player) bool {
+	if state.stage != Playing || state.turn.choosing || state.turn.describing {
+		return false
+	}
+	return !state.onTurn(guesser) && !state.turn.guessers[guesser.ID]
+}
+
+// whether the guess is only a few typos away from the word, a close guess isn't correct. only a message as many words
+// long as the word can be close, so chat that happens to contain a word near it like "that" for "hat" is broadcast
+func (state *GameState) IsCloseGuess(guesser Player, text string) bool {
+	if !state.canGuess(guesser) {
+		return false
+	}
+	if len(normalizeWords(text)) != len(normalizeWords(state.turn.currWord)) {
+		return false
+	}
+	distance := guessDistance(text, state.turn.currWord)
+	return distance > 0 && distance <= closeDistance(state.turn.currWord)
 }
 
 func (state *GameState) incScore(player Player, s Score) {
@@ -328,14 +761,91 @@ func (state *GameState) incScore(player Player, s Score) {
 	state.scoreBoard[player.ID] = score
 }
 
+// every player on the turn is scored for the drawing, the describer of a telephone turn included
 func (state *GameState) OnReset() int {
 	pointsInc := state.calcResetScore()
-	state.incScore(state.GetCurrPlayer(), Score{Points: pointsInc, drawings: 1})
+	for _, index := range state.turn.drawers {
+		state.incScore(state.players[index], Score{Points: pointsInc, drawings: 1})
+	}
+	if state.turn.describer >= 0 {
+		state.incScore(state.players[state.turn.describer], Score{Points: pointsInc})
+	}
 	return pointsInc
 }
 
 func (state *GameState) calcResetScore() int {
-	return len(state.turn.guessers) * 50
+	// every present player not on the turn could have guessed the word, the players on it may have left already
+	eligible := 0
+	for _, player := range state.Players() {
+		if !state.onTurn(player) {
+			eligible++
+		}
+	}
+	draw := DrawContext{Guessers: len(state.turn.guessers), Eligible: eligible, Drawers: len(state.turn.drawers)}
+	return state.scoring().DrawPoints(draw)
+}
+
+// the game mode the room was created with, settings from before modes could be picked play the classic game
+func (state *GameState) mode() GameMode {
+	mode, ok := gameModes[state.settings.Mode]
+	if !ok {
+		return ClassicGame{}
+	}
+	return mode
+}
+
+// the seconds players have to draw and guess the word of each turn
+func (state *GameState) TimeLimit() int {
+	return state.mode().TimeLimit(state.settings)
+}
+
+func (state *GameState) scoring() ScoringPolicy {
+	return state.mode().Scoring(state.settings)
+}
+
+// sets what the drawer draws from, a description can't contain the word or give it away with a typo
+func (state *GameState) Describe(player Player, text string) error {
+	if state.stage != Playing || !state.turn.describing {
+		return errors.New("The word can only be described before the turn begins")
+	}
+	if !state.isDescriber(player) {
+		return errors.New("Only the describer can describe the word")
+	}
+	if len(text) > MaxDescribeLen || len(text) < MinChatLen {
+		return fmt.Errorf("Description must be less than %d characters in length and more than %d", MaxDescribeLen, MinChatLen)
+	}
+	distance := guessDistance(text, state.turn.currWord)
+	if distance >= 0 && distance <= closeDistance(state.turn.currWord) {
+		return errors.New("The description cannot contain the word")
+	}
+	state.setDescription(text)
+	return nil
+}
+
+// the drawing time starts once the word is described
+func (state *GameState) setDescription(text string) {
+	state.turn.description = text
+	state.turn.describing = false
+	state.resetStartTime()
+}
+
+// the description is only for the players on the turn and players who can see the word
+func (state *GameState) descriptionFor(viewer Player) string {
+	if state.CanSeeWord(viewer) || state.onTurn(viewer) {
+		return state.turn.description
+	}
+	return ""
+}
+
+// hands the canvas to the next present drawer of a relay, returns false if there is nobody left to hand it to
+func (state *GameState) PassCanvas() bool {
+	for leg := state.turn.leg + 1; leg < len(state.turn.drawers); leg++ {
+		if state.players[state.turn.drawers[leg]].present {
+			state.turn.leg = leg
+			return true
+		}
+	}
+	return false
 }
 
 func (state *GameState) HasMoreRounds() bool {
@@ -380,9 +890,26 @@ func (state *GameState) CreateGameResults() []GameResult {
 	sort.Slice(results, func(i, j int) bool {
 		return compareResults(results[i], results[j])
 	})
-	if len(results) > 0 {
+	if state.IsTeamMode() {
+		// every member of the winning team wins, even those with fewer points than a player on another team
+		winners := state.winningTeams()
+		for i := range results {
+			id, _ := uuid.Parse(results[i].PlayerID)
+			team, ok := state.teams[id]
+			results[i].Win = ok && winners[team]
+		}
+	} else if len(results) > 0 {
 		results[0].Win = true
 	}
 
 	return results
 }
+
+// the results of a game that was cut short, the points scored still count but nobody won a game that didn't finish
+func (state *GameState) CreateInterruptedResults() []GameResult {
+	results := state.CreateGameResults()
+	for i := range results {
+		results[i].Win = false
+	}
+	return results
+}
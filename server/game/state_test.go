@@ -8,9 +8,13 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"github.com/google/uuid"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestState_Join(t *testing.T) {
@@ -112,6 +116,412 @@ func TestState_StartGame(t *testing.T) {
 	}
 }
 
+func TestState_ChooseWord(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"Word1", "Word2", "Word3"}
+	settings.WordChoices = 3
+	state := NewGameState("123", settings)
+
+	_ = state.Join(Player{ID: uuid.New()})
+	state.StartGame()
+
+	if !state.turn.choosing || state.turn.currWord != "" {
+		t.Fatalf("Expected the drawer to be choosing the word")
+	}
+	if len(state.turn.candidates) != 3 || state.turn.candidates[0] == state.turn.candidates[1] {
+		t.Fatalf("Expected 3 distinct candidates, got %v", state.turn.candidates)
+	}
+	if err := state.ChooseWord(3); err == nil {
+		t.Fatalf("Expected a word outside the candidates to be rejected")
+	}
+
+	exp := state.turn.candidates[1]
+	if err := state.ChooseWord(1); err != nil {
+		t.Fatalf("Failed to choose the word %v", err)
+	}
+	if state.turn.choosing || state.turn.currWord != exp {
+		t.Fatalf("Expected the current word to be %s, got %s", exp, state.turn.currWord)
+	}
+	if err := state.ChooseWord(0); err == nil {
+		t.Fatalf("Expected the word to only be chosen once")
+	}
+}
+
+func TestState_MaskedWord(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"ice cream"}
+	state := NewGameState("123", settings)
+
+	guesser := Player{ID: uuid.New()}
+	drawer := Player{ID: uuid.New()}
+	_ = state.Join(guesser)
+	_ = state.Join(drawer)
+	state.StartGame()
+
+	type TestView struct {
+		viewer  Player
+		expWord string
+	}
+	tests := []TestView{
+		{viewer: drawer, expWord: "ice cream"},
+		{viewer: guesser, expWord: "___ _____"},
+	}
+	for i, test := range tests {
+		var stateJson StateJson
+		_ = json.Unmarshal(state.MarshalJson(test.viewer, false), &stateJson)
+		if stateJson.Turn.CurrWord != test.expWord {
+			t.Fatalf("Expected the word %s for view %d, got %s", test.expWord, i, stateJson.Turn.CurrWord)
+		}
+	}
+
+	// the word is revealed to the guesser once it was guessed
+	state.turn.guessers[guesser.ID] = true
+	if state.wordFor(guesser) != "ice cream" {
+		t.Fatalf("Expected the word to be revealed to a player who guessed it")
+	}
+}
+
+func TestState_RevealHint(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"quick"}
+	state := NewGameState("123", settings)
+	_ = state.Join(Player{ID: uuid.New()})
+	state.StartGame()
+
+	// every letter but the last hidden one can be revealed
+	for i := 0; i < 4; i++ {
+		if !state.RevealHint() {
+			t.Fatalf("Expected hint %d to be revealed", i)
+		}
+	}
+	if state.RevealHint() {
+		t.Fatalf("Expected the last hidden letter to stay hidden")
+	}
+	if hidden := strings.Count(state.MaskedWord(), "_"); hidden != 1 {
+		t.Fatalf("Expected a single hidden letter, got %s", state.MaskedWord())
+	}
+}
+
+func TestState_TryGuess_AfterHints(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+	guesser := Player{ID: uuid.New()}
+	state.players = []Player{{ID: uuid.New()}, guesser}
+	state.StartGame()
+	state.turn.currWord = "quick"
+	state.turn.currPlayerIndex = 0
+
+	state.turn.revealed = map[int]bool{0: true, 3: true}
+	base := state.scoring().GuessPoints(GuessContext{TimeLimit: time.Duration(state.settings.TimeLimitSecs) * time.Second})
+	exp := base - base*2*state.settings.HintPenalty/100
+	if pointsInc := state.TryGuess(guesser, "quick").GuessPointsInc; pointsInc != exp {
+		t.Fatalf("Expected a guess after 2 hints to score %d, got %d", exp, pointsInc)
+	}
+}
+
+func TestState_ChatLogForViewer(t *testing.T) {
+	settings := MockSettings()
+	settings.SharedWordBank = []string{"quick"}
+	state := NewGameState("123", settings)
+
+	guesser := Player{ID: uuid.New()}
+	drawer := Player{ID: uuid.New()}
+	_ = state.Join(guesser)
+	_ = state.Join(drawer)
+	state.StartGame()
+
+	if _, err := state.GuessedChat(drawer, "the word is quick"); err == nil {
+		t.Fatalf("Expected the drawer to be unable to say the word")
+	}
+	_, _ = state.GuessedChat(drawer, "draw faster")
+	state.TryGuess(guesser, "hello there")
+
+	if len(state.chatLogFor(drawer)) != 2 || len(state.chatLogFor(guesser)) != 1 {
+		t.Fatalf("Expected the guessed channel to be left out of the chat log for the guesser")
+	}
+}
+
+func TestState_HostMigration(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	player1 := Player{ID: uuid.New()}
+	player2 := Player{ID: uuid.New()}
+	player3 := Player{ID: uuid.New()}
+
+	type TestHost struct {
+		join    *Player
+		leave   *Player
+		expHost uuid.UUID
+	}
+	tests := []TestHost{
+		{join: &player1, expHost: player1.ID},
+		{join: &player2, expHost: player1.ID},
+		{join: &player3, expHost: player1.ID},
+		// player 2 has been present longer than player 1 once player 1 rejoins
+		{leave: &player1, expHost: player2.ID},
+		{join: &player1, expHost: player2.ID},
+		{leave: &player2, expHost: player3.ID},
+		{leave: &player3, expHost: player1.ID},
+		{leave: &player1, expHost: uuid.Nil},
+		// an empty room is given to whoever joins it next
+		{join: &player2, expHost: player2.ID},
+	}
+	for i, test := range tests {
+		if test.join != nil {
+			_ = state.Join(*test.join)
+		}
+		if test.leave != nil {
+			state.Leave(*test.leave)
+		}
+		if state.host != test.expHost {
+			t.Fatalf("Expected host %v after step %d, got %v", test.expHost, i, state.host)
+		}
+	}
+}
+
+func TestState_TransferHost(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	player1 := Player{ID: uuid.New()}
+	player2 := Player{ID: uuid.New()}
+	_ = state.Join(player1)
+	_ = state.Join(player2)
+
+	if err := state.TransferHost(Player{ID: uuid.New()}); err == nil {
+		t.Fatalf("Expected transferring the host to a player not in the room to fail")
+	}
+	if err := state.TransferHost(player2); err != nil || state.PlayerIsNotHost(player2) {
+		t.Fatalf("Expected the host to be transferred to player 2")
+	}
+	if !state.PlayerIsNotHost(player1) {
+		t.Fatalf("Expected player 1 to no longer be the host")
+	}
+}
+
+func TestState_Rematch(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	player1 := Player{ID: uuid.New()}
+	player2 := Player{ID: uuid.New()}
+	_ = state.Join(player1)
+	_ = state.Join(player2)
+	state.Leave(player2)
+
+	state.StartGame()
+	state.incScore(player1, Score{Points: 100})
+	state.TryGuess(player1, "Hello 123")
+	state.FinishGame()
+
+	settings := MockSettings()
+	settings.TotalRounds = 5
+	match := state.Rematch(settings)
+
+	if match.ScoreBoard[player1.ID].Points != 100 || len(state.matches) != 1 {
+		t.Fatalf("Expected the finished game to be archived, got %v", state.matches)
+	}
+	if state.stage != Lobby || state.currRound != 0 || len(state.chatLog) != 0 || state.settings.TotalRounds != 5 {
+		t.Fatalf("Expected the state to be reset for a new game")
+	}
+	// players who left the finished game don't carry over
+	expScoreBoard := map[uuid.UUID]Score{player1.ID: {}}
+	if !reflect.DeepEqual(state.scoreBoard, expScoreBoard) || len(state.players) != 1 {
+		t.Fatalf("Expected only present players to stay with reset scores, got %v", state.scoreBoard)
+	}
+}
+
+func TestState_VoteRematch(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+
+	type TestVote struct {
+		player   Player
+		expVotes int
+	}
+	tests := []TestVote{
+		{player: players[1], expVotes: 1},
+		{player: players[1], expVotes: 1},
+		{player: players[2], expVotes: 2},
+	}
+	for i, test := range tests {
+		votes, needed := state.VoteRematch(test.player)
+		if votes != test.expVotes || needed != 2 {
+			t.Fatalf("Expected %d of 2 votes for vote %d, got %d of %d", test.expVotes, i, votes, needed)
+		}
+	}
+}
+
+func TestState_CycleSkipsAbsentPlayers(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.Leave(players[2])
+
+	// the first turn goes to the second player, then the absent third player is skipped into the next round
+	expIndices := []int{1, 0, 1}
+	expRounds := []int{0, 1, 1}
+	for i := range expIndices {
+		state.StartGame()
+		if state.turn.currPlayerIndex != expIndices[i] || state.currRound != expRounds[i] {
+			t.Fatalf("Expected turn %d to be player %d in round %d, got player %d in round %d",
+				i, expIndices[i], expRounds[i], state.turn.currPlayerIndex, state.currRound)
+		}
+	}
+}
+
+func TestState_JoinMidGame(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.StartGame()
+
+	// the late player waits for the next round, and the second player leaving and rejoining keeps its place
+	late := Player{ID: uuid.New()}
+	_ = state.Join(late)
+	state.Leave(players[1])
+	_ = state.Join(players[1])
+	if !slices.Equal(state.Queued(), []uuid.UUID{late.ID}) {
+		t.Fatalf("Expected only the late player to be queued, got %v", state.Queued())
+	}
+
+	expIndices := []int{2, 0, 1, 2, 3}
+	expRounds := []int{0, 1, 1, 1, 1}
+	for i := range expIndices {
+		state.StartGame()
+		if state.turn.currPlayerIndex != expIndices[i] || state.currRound != expRounds[i] {
+			t.Fatalf("Expected turn %d to be player %d in round %d, got player %d in round %d",
+				i, expIndices[i], expRounds[i], state.turn.currPlayerIndex, state.currRound)
+		}
+	}
+	if len(state.Queued()) != 0 {
+		t.Fatalf("Expected the queue to be cleared once the round wraps around, got %v", state.Queued())
+	}
+}
+
+func TestState_CycleToQueuedPlayers(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.StartGame()
+
+	// once everyone who started the game left, the queued player draws in the next round
+	late := Player{ID: uuid.New()}
+	_ = state.Join(late)
+	state.Leave(players[0])
+	state.Leave(players[1])
+
+	state.StartGame()
+	if state.turn.currPlayerIndex != 2 || state.currRound != 1 {
+		t.Fatalf("Expected the queued player to draw in round 1, got player %d in round %d",
+			state.turn.currPlayerIndex, state.currRound)
+	}
+}
+
+func TestState_PlayerLimit(t *testing.T) {
+	settings := MockSettings()
+	settings.PlayerLimit = 2
+	state := NewGameState("123", settings)
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	_ = state.Join(players[0])
+	_ = state.Join(players[1])
+	if state.Join(players[2]) == nil {
+		t.Fatalf("Expected a player to be kept out of a full room")
+	}
+	if err := state.Join(players[1]); err != nil {
+		t.Fatalf("Expected a present player to join again from another socket, got %v", err)
+	}
+
+	// a player who left doesn't take up a place, but can only rejoin while there is one
+	state.Leave(players[1])
+	if err := state.Join(players[2]); err != nil {
+		t.Fatalf("Expected a player to take the place of a player who left, got %v", err)
+	}
+	if state.Join(players[1]) == nil {
+		t.Fatalf("Expected a player who left to be kept out once the room is full again")
+	}
+	state.Leave(players[0])
+	if err := state.Join(players[1]); err != nil {
+		t.Fatalf("Expected a player who left to rejoin once there is a place, got %v", err)
+	}
+}
+
+func TestState_JoinMidGameSnapshot(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.StartGame()
+
+	late := Player{ID: uuid.New()}
+	_ = state.Join(late)
+
+	// the late player sees the turn in progress with its drawer and deadline
+	stateJson := state.toJson(late, "")
+	if stateJson.Stage != Playing {
+		t.Fatalf("Expected the snapshot to be in the playing stage, got %d", stateJson.Stage)
+	}
+	if stateJson.Turn.CurrPlayer == nil || stateJson.Turn.CurrPlayer.ID != players[1].ID {
+		t.Fatalf("Expected the snapshot to show the drawer of the turn, got %v", stateJson.Turn.CurrPlayer)
+	}
+	if stateJson.Turn.Deadline != state.TurnDeadline().UnixMilli() {
+		t.Fatalf("Expected the snapshot to show the deadline of the turn")
+	}
+	if stateJson.Turn.CurrWord == state.turn.currWord {
+		t.Fatalf("Expected the word to be hidden from the late player")
+	}
+	if !slices.Equal(stateJson.Queued, []uuid.UUID{late.ID}) {
+		t.Fatalf("Expected the late player to be queued, got %v", stateJson.Queued)
+	}
+}
+
+func TestState_AllGuessed(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	state.StartGame()
+
+	// the second player draws, so the other two players need to guess
+	type TestGuessed struct {
+		guesser   *Player
+		leaver    *Player
+		expResult bool
+	}
+	tests := []TestGuessed{
+		{expResult: false},
+		{guesser: &players[0], expResult: false},
+		{leaver: &players[2], expResult: true},
+		{leaver: &players[0], expResult: false},
+	}
+	for i, test := range tests {
+		if test.guesser != nil {
+			state.turn.guessers[test.guesser.ID] = true
+		}
+		if test.leaver != nil {
+			state.Leave(*test.leaver)
+		}
+		if state.AllGuessed() != test.expResult {
+			t.Fatalf("Expected all guessed to be %t for test %d", test.expResult, i)
+		}
+	}
+}
+
 func TestState_TryGuess(t *testing.T) {
 	state := NewGameState("123", MockSettings())
 
@@ -227,3 +637,62 @@ func TestState_EncodeCanvas(t *testing.T) {
 		t.Fatalf("Canvas is not the same after encoding then decoding - binary serialization does not work")
 	}
 }
+
+func TestState_EncodeBinary(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+	state.turn.canvas = []Circle{
+		{Color: 4, Radius: 3, X: 2, Y: 1, Connected: true},
+		{Color: 5, X: 1, Y: 2, Connected: false}}
+
+	buf := state.EncodeBinary(Player{}, false)
+	if buf[0] != StateCode {
+		t.Fatalf("Expected binary state frame to start with the state code")
+	}
+
+	jsonLen := int(binary.LittleEndian.Uint32(buf[1:5]))
+	canvas, err := unpackCircles(buf[5+jsonLen:])
+	if err != nil {
+		t.Fatalf("Error unpacking canvas %v", err)
+	}
+	if !reflect.DeepEqual(state.turn.canvas, canvas) {
+		t.Fatalf("Canvas is not the same after packing then unpacking")
+	}
+}
+
+func TestStrokeMsg_Circles(t *testing.T) {
+	stroke := StrokeMsg{Color: 2, Radius: 1, Points: []Point{{X: 1, Y: 2}, {X: 3, Y: 4}, {X: 5, Y: 6}}}
+
+	expCircles := []Circle{
+		{Color: 2, Radius: 1, X: 1, Y: 2, Connected: false},
+		{Color: 2, Radius: 1, X: 3, Y: 4, Connected: true},
+		{Color: 2, Radius: 1, X: 5, Y: 6, Connected: true},
+	}
+	if !reflect.DeepEqual(stroke.Circles(), expCircles) {
+		t.Fatalf("Expected stroke to expand into connected circles, got %+v", stroke.Circles())
+	}
+}
+
+func TestState_CompressCanvas(t *testing.T) {
+	state := NewGameState("123", MockSettings())
+	for i := 0; i < 500; i++ {
+		circle := Circle{Color: 3, Radius: 2, X: uint16(500 + i%40), Y: uint16(500 - i%25), Connected: i%50 != 0}
+		state.turn.canvas = append(state.turn.canvas, circle)
+	}
+
+	compressed, err := state.canvasBytes(true)
+	if err != nil {
+		t.Fatalf("Error compressing canvas %v", err)
+	}
+	packed, _ := state.canvasBytes(false)
+	if len(compressed) >= len(packed) {
+		t.Fatalf("Expected compressed canvas of %d bytes to be smaller than packed canvas of %d bytes", len(compressed), len(packed))
+	}
+
+	canvas, err := decompressCircles(compressed)
+	if err != nil {
+		t.Fatalf("Error decompressing canvas %v", err)
+	}
+	if !reflect.DeepEqual(state.turn.canvas, canvas) {
+		t.Fatalf("Canvas is not the same after compressing then decompressing")
+	}
+}
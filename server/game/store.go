@@ -5,6 +5,7 @@
 package game
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -16,10 +17,16 @@ type Brokerage interface {
 	Codes(offset int, limit int) []string
 }
 
+// implemented by brokerages that only own a subset of room codes, such as a single node in a cluster
+type Partitioned interface {
+	Owns(code string) bool
+}
+
 type BrokerStore struct {
-	m     map[string]Broker // maps codes to brokers
-	codes []string          // stores the codes of the older brokers last
-	mu    sync.Mutex        // used to synchronize both structures
+	m      map[string]Broker // maps codes to brokers
+	codes  []string          // stores the codes of the older brokers last
+	closed bool              // set once the store is shut down, no brokers can be stored after
+	mu     sync.Mutex        // used to synchronize both structures
 }
 
 func NewBrokerStore(period time.Duration) *BrokerStore {
@@ -46,6 +53,12 @@ func (store *BrokerStore) Set(code string, b Broker) {
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
+	if store.closed {
+		// the broker was created while the store was shutting down, so it must be stopped like the others
+		go b.Stop(ShutdownCode)
+		return
+	}
+
 	store.m[code] = b
 	// only add codes for public broker into the list of all codes
 	if b.IsPublic() {
@@ -72,18 +85,24 @@ func (store *BrokerStore) Codes(offset int, limit int) []string {
 }
 
 func (store *BrokerStore) purgeExpired(now time.Time) {
+	expiredBrokers := store.removeExpired(now)
+	// brokers are stopped outside the lock since stopping waits for each of them to terminate
+	<-stopBrokers(expiredBrokers, TimeoutCode)
+	log.Println("Finished purging brokers for store")
+}
+
+func (store *BrokerStore) removeExpired(now time.Time) []Broker {
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
+	var expiredBrokers []Broker
 	expiredCodes := make(map[string]bool)
 	for code, broker := range store.m {
 		// check if this broker has expired already, if so delete it
 		if broker.IsExpired(now) {
 			log.Printf("Deleting broker for code %s", code)
 
-			// send the termination signal to the broker
-			go broker.Stop(TimeoutCode)
-
+			expiredBrokers = append(expiredBrokers, broker)
 			delete(store.m, code)
 			expiredCodes[code] = true
 		}
@@ -103,7 +122,48 @@ func (store *BrokerStore) purgeExpired(now time.Time) {
 			i--
 		}
 	}
-	log.Println("Finished purging brokers for store")
+	return expiredBrokers
+}
+
+// stops every broker with the shutdown code and waits for them to terminate, or for the context to be done
+func (store *BrokerStore) Shutdown(ctx context.Context) error {
+	store.mu.Lock()
+	store.closed = true
+	brokers := make([]Broker, 0, len(store.m))
+	for _, broker := range store.m {
+		brokers = append(brokers, broker)
+	}
+	store.m = make(map[string]Broker)
+	store.codes = make([]string, 0)
+	store.mu.Unlock()
+
+	log.Printf("Shutting down %d brokers", len(brokers))
+
+	select {
+	case <-stopBrokers(brokers, ShutdownCode):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sends the termination signal to each broker at once, the returned channel is closed when all of them terminated
+func stopBrokers(brokers []Broker, code int) <-chan struct{} {
+	var wg sync.WaitGroup
+	for _, broker := range brokers {
+		wg.Add(1)
+		go func(broker Broker) {
+			defer wg.Done()
+			broker.Stop(code)
+		}(broker)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
 }
 
 func (store *BrokerStore) startCleanup(period time.Duration) {
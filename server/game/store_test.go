@@ -5,6 +5,8 @@
 package game
 
 import (
+	"context"
+	"guessthesketch/pubsub"
 	"reflect"
 	"testing"
 	"time"
@@ -20,7 +22,7 @@ func (stub *StubBroker) Start() {}
 
 func (stub *StubBroker) Join(_ SubscriberMsg) {}
 
-func (stub *StubBroker) Leave(_ chan []byte) {}
+func (stub *StubBroker) Leave(_ chan pubsub.Message) {}
 
 func (stub *StubBroker) SendMessage(_ SentMsg) {}
 
@@ -127,3 +129,29 @@ func TestBrokerStore_Cleanup(t *testing.T) {
 		t.Fatalf("Expected codes to be an empty slice after purging all expired codes")
 	}
 }
+
+func TestBrokerStore_Shutdown(t *testing.T) {
+	store := NewBrokerStore(time.Minute)
+
+	stubs := []*StubBroker{{code: "123"}, {code: "456"}}
+	for _, stub := range stubs {
+		store.Set(stub.code, stub)
+	}
+
+	err := store.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Expected shutdown to finish, got %v", err)
+	}
+
+	for i, stub := range stubs {
+		if stub.stopCode != ShutdownCode {
+			t.Fatalf("Expected stop code for room %d to be shutdown", i)
+		}
+		if store.Get(stub.code) != nil {
+			t.Fatalf("Expected room %d to be removed after shutdown", i)
+		}
+	}
+	if len(store.Codes(0, 10)) != 0 {
+		t.Fatalf("Expected no codes after shutdown")
+	}
+}
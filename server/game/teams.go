@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+func (state *GameState) IsTeamMode() bool {
+	return state.settings.TeamCount > 0
+}
+
+// puts a player without a team on the team with the fewest present players, a player rejoining keeps its team
+func (state *GameState) assignTeam(player Player) {
+	if !state.IsTeamMode() {
+		return
+	}
+	if _, ok := state.teams[player.ID]; ok {
+		return
+	}
+
+	sizes := state.teamSizes()
+	smallest := 0
+	for team, size := range sizes {
+		if size < sizes[smallest] {
+			smallest = team
+		}
+	}
+	state.teams[player.ID] = smallest
+}
+
+// the present players on each team
+func (state *GameState) teamSizes() []int {
+	sizes := make([]int, state.settings.TeamCount)
+	for _, player := range state.Players() {
+		if team, ok := state.teams[player.ID]; ok {
+			sizes[team]++
+		}
+	}
+	return sizes
+}
+
+// moves the player to the team it picked, teams can only be picked before the game starts
+func (state *GameState) ChooseTeam(player Player, team int) error {
+	if !state.IsTeamMode() {
+		return errors.New("Teams can only be chosen in team mode")
+	}
+	if state.stage != Lobby {
+		return errors.New("Teams can only be chosen in the lobby")
+	}
+	if team < 0 || team >= state.settings.TeamCount {
+		return fmt.Errorf("Team must be between %d and %d", 0, state.settings.TeamCount-1)
+	}
+	state.teams[player.ID] = team
+	return nil
+}
+
+// a team game can't start with a team nobody is on
+func (state *GameState) TeamsReady() error {
+	if !state.IsTeamMode() {
+		return nil
+	}
+	for _, size := range state.teamSizes() {
+		if size == 0 {
+			return errors.New("Every team needs at least one player")
+		}
+	}
+	return nil
+}
+
+// the team of each player, nil unless the room is in team mode
+func (state *GameState) Teams() map[uuid.UUID]int {
+	if !state.IsTeamMode() {
+		return nil
+	}
+	return state.teams
+}
+
+// the teams take turns drawing, and the members of each team take turns drawing for it. a round is over once every
+// team drew, teams without anyone present are skipped
+func (state *GameState) cycleTeamDrawer() {
+	turn := &state.turn
+	for skipped := 0; skipped < state.settings.TeamCount; skipped++ {
+		turn.team += 1
+		if turn.team >= state.settings.TeamCount {
+			turn.team = 0
+			state.nextRound()
+		}
+
+		index := state.nextTeamMember(turn.team)
+		if index >= 0 {
+			turn.currPlayerIndex = index
+			state.teamDrawers[turn.team] = index
+			return
+		}
+	}
+}
+
+// finds the present member of the team after the one who last drew for it, or -1 if nobody on the team can draw
+func (state *GameState) nextTeamMember(team int) int {
+	last, ok := state.teamDrawers[team]
+	if !ok {
+		last = -1
+	}
+	for i := 1; i <= len(state.players); i++ {
+		index := (last + i) % len(state.players)
+		if state.canDraw(index) && state.teams[state.players[index].ID] == team {
+			return index
+		}
+	}
+	return -1
+}
+
+// the sum of the points of every player on each team, including players who left
+func (state *GameState) TeamScores() []int {
+	if !state.IsTeamMode() {
+		return nil
+	}
+	scores := make([]int, state.settings.TeamCount)
+	for id, score := range state.scoreBoard {
+		if team, ok := state.teams[id]; ok {
+			scores[team] += score.Points
+		}
+	}
+	return scores
+}
+
+// the teams with the most points, every team tied for the most points wins
+func (state *GameState) winningTeams() map[int]bool {
+	scores := state.TeamScores()
+	best := 0
+	for _, score := range scores {
+		best = max(best, score)
+	}
+	winners := make(map[int]bool)
+	for team, score := range scores {
+		if score == best {
+			winners[team] = true
+		}
+	}
+	return winners
+}
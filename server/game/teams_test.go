@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package game
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func MockTeamSettings() RoomSettings {
+	settings := MockSettings()
+	settings.TeamCount = 2
+	return settings
+}
+
+func TestState_AssignTeams(t *testing.T) {
+	state := NewGameState("123", MockTeamSettings())
+
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+	if sizes := state.teamSizes(); sizes[0] != 2 || sizes[1] != 2 {
+		t.Fatalf("Expected the teams to be balanced, got %v", sizes)
+	}
+
+	// a new player fills the spot of the player who left
+	team := state.teams[players[2].ID]
+	state.Leave(players[2])
+	newPlayer := Player{ID: uuid.New()}
+	_ = state.Join(newPlayer)
+	if state.teams[newPlayer.ID] != team {
+		t.Fatalf("Expected the new player to join the smaller team %d", team)
+	}
+}
+
+func TestState_ChooseTeam(t *testing.T) {
+	state := NewGameState("123", MockTeamSettings())
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+
+	if err := state.ChooseTeam(players[1], 2); err == nil {
+		t.Fatalf("Expected a team outside the team count to be rejected")
+	}
+	if err := state.ChooseTeam(players[1], 0); err != nil {
+		t.Fatalf("Failed to choose a team %v", err)
+	}
+	if err := state.TeamsReady(); err == nil {
+		t.Fatalf("Expected a game with an empty team to be unable to start")
+	}
+
+	_ = state.ChooseTeam(players[0], 1)
+	if err := state.TeamsReady(); err != nil {
+		t.Fatalf("Expected the teams to be ready %v", err)
+	}
+	state.StartGame()
+	if err := state.ChooseTeam(players[0], 0); err == nil {
+		t.Fatalf("Expected teams to be fixed once the game started")
+	}
+}
+
+func TestState_CycleTeamDrawer(t *testing.T) {
+	state := NewGameState("123", MockTeamSettings())
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+
+	// the teams alternate and each team's members take turns drawing for it, every team draws in the first round
+	state.settings.TotalRounds = 1
+	type TestTurn struct {
+		expDrawer Player
+		expRound  int
+	}
+	tests := []TestTurn{
+		{expDrawer: players[0], expRound: 0},
+		{expDrawer: players[1], expRound: 0},
+		{expDrawer: players[2], expRound: 1},
+		{expDrawer: players[3], expRound: 1},
+		{expDrawer: players[0], expRound: 2},
+	}
+	for i, test := range tests {
+		state.StartGame()
+		if state.GetCurrPlayer().ID != test.expDrawer.ID || state.currRound != test.expRound {
+			t.Fatalf("Expected player %v to draw turn %d in round %d, got %v in round %d",
+				test.expDrawer, i, test.expRound, state.GetCurrPlayer(), state.currRound)
+		}
+		expMore := test.expRound < state.settings.TotalRounds
+		if state.HasMoreRounds() != expMore {
+			t.Fatalf("Expected more rounds to be %t on turn %d in round %d", expMore, i, test.expRound)
+		}
+	}
+}
+
+func TestState_TeamGameResults(t *testing.T) {
+	state := NewGameState("123", MockTeamSettings())
+	players := []Player{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	for _, player := range players {
+		_ = state.Join(player)
+	}
+
+	// the first team has more points even though the best player is on the second team
+	state.scoreBoard[players[0].ID] = Score{Points: 300}
+	state.scoreBoard[players[2].ID] = Score{Points: 100}
+	state.scoreBoard[players[1].ID] = Score{Points: 350}
+	state.scoreBoard[players[3].ID] = Score{Points: 0}
+
+	if scores := state.TeamScores(); scores[0] != 400 || scores[1] != 350 {
+		t.Fatalf("Expected the team scores to be aggregated, got %v", scores)
+	}
+	for _, result := range state.CreateGameResults() {
+		id, _ := uuid.Parse(result.PlayerID)
+		expWin := state.teams[id] == 0
+		if result.Win != expWin {
+			t.Fatalf("Expected the win for player %s to be %t", result.PlayerID, expWin)
+		}
+	}
+}
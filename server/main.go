@@ -5,21 +5,38 @@
 package main
 
 import (
+	"context"
 	"embed"
 	_ "embed"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
+	"guessthesketch/cluster"
+	"guessthesketch/database"
 	"guessthesketch/game"
+	"guessthesketch/pubsub"
 	"guessthesketch/servers"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// the deadline for draining the server once it is told to stop, container runtimes usually kill it after 10 seconds
+const ShutdownTimeout = 8 * time.Second
+
+// background writes run on a few workers, a failed write is retried with exponential backoff before it is dead-lettered
+const (
+	JobWorkers  = 4
+	JobAttempts = 8
+	JobBackoff  = time.Second
+)
+
 //go:embed words.txt
 var words string
 
@@ -40,12 +57,51 @@ func main() {
 	gameWordBank := strings.Split(words, "\n")
 
 	telemetryServer := servers.NewTelemetryServer()
-	roomServer := servers.NewRoomServer(db)
+	jobs, err := database.NewJobQueue(db, JobWorkers, JobAttempts, JobBackoff)
+	if err != nil {
+		log.Fatalf("Failed to create the job queue: %v", err)
+	}
+	roomServer := servers.NewRoomServer(jobs)
+	roomServer.SnapshotOnShutdown = envVars["SNAPSHOT_ON_SHUTDOWN"] == "true"
 	authServer := servers.NewAuthServer(jwtSecretKey)
 	playerServer := servers.NewPlayerServer(db, authServer)
 	drawingServer := servers.NewDrawingServer(db)
 	brokerStore := game.NewBrokerStore(time.Minute)
-	roomsServer := servers.NewRoomsServer(brokerStore, authServer, roomServer, gameWordBank)
+
+	// rooms publish their frames through redis when configured, so subscribers on any node can receive them
+	var ps pubsub.PubSub
+	if envVars["REDIS_ADDR"] != "" {
+		redisPubSub, err := pubsub.DialRedis(envVars["REDIS_ADDR"])
+		if err != nil {
+			log.Fatalf("Failed to connect to redis at %s: %v", envVars["REDIS_ADDR"], err)
+		}
+		defer redisPubSub.Close()
+		ps = redisPubSub
+	}
+
+	// a node in a cluster only owns some rooms and forwards subscribers for the others to their owners
+	var brokerage game.Brokerage = brokerStore
+	var clusterBrokerage *cluster.ClusterBrokerage
+	if envVars["CLUSTER_NODES"] != "" {
+		// peers are trusted to forward any player, so a cluster can't run without a secret to authenticate them
+		if envVars["CLUSTER_SECRET"] == "" {
+			log.Fatalf("CLUSTER_SECRET must be set when CLUSTER_NODES is set")
+		}
+		// a node that isn't in the ring would never own a room, so its address must match its entry exactly
+		nodes := strings.Split(envVars["CLUSTER_NODES"], ",")
+		if !slices.Contains(nodes, envVars["CLUSTER_SELF"]) {
+			log.Fatalf("CLUSTER_SELF %q must be one of CLUSTER_NODES %q", envVars["CLUSTER_SELF"], envVars["CLUSTER_NODES"])
+		}
+		clusterBrokerage = cluster.NewClusterBrokerageWithPubSub(
+			brokerStore, envVars["CLUSTER_SELF"], nodes, envVars["CLUSTER_SECRET"], 5*time.Second, ps)
+		brokerage = clusterBrokerage
+	}
+
+	if err = jobs.Start(); err != nil {
+		log.Fatalf("Failed to start the job queue: %v", err)
+	}
+
+	roomsServer := servers.NewRoomsServerWithPubSub(brokerage, authServer, roomServer, gameWordBank, ps)
 
 	router := mux.NewRouter()
 	apiRouter := router.PathPrefix("/api").Subrouter()
@@ -58,10 +114,49 @@ func main() {
 	apiRouter.HandleFunc("/logout", authServer.Logout)
 	apiRouter.HandleFunc("/telemetry/subscribe", telemetryServer.Subscribe)
 	apiRouter.HandleFunc("/drawings", drawingServer.GetDrawings)
+	if clusterBrokerage != nil {
+		clusterServer := servers.NewClusterServer(clusterBrokerage, roomsServer, envVars["CLUSTER_SECRET"])
+		apiRouter.HandleFunc("/cluster/rooms", clusterServer.Rooms)
+		apiRouter.HandleFunc("/cluster/join", clusterServer.Join)
+	}
 	addFileServer(router)
 
-	log.Println("Starting the server...")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	httpServer := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		log.Println("Starting the server...")
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdown(httpServer, roomsServer, brokerStore, roomServer)
+}
+
+// drains the server: stops accepting rooms, terminates the rooms it owns, then waits for their results to be saved
+func shutdown(
+	httpServer *http.Server, roomsServer *servers.RoomsServer,
+	brokerStore *game.BrokerStore, roomServer *servers.RoomServer) {
+
+	log.Println("Shutting down the server...")
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	roomsServer.Drain()
+	if err := brokerStore.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop all rooms before the deadline: %v", err)
+	}
+	if err := roomServer.Flush(ctx); err != nil {
+		log.Printf("Failed to save all game results: %v", err)
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to close the http server before the deadline: %v", err)
+	}
+	log.Println("Finished shutting down the server")
 }
 
 func createDb(dbFile string) *sqlx.DB {
@@ -75,7 +170,8 @@ func createDb(dbFile string) *sqlx.DB {
 		}
 	}
 
-	db, err := sqlx.Open("sqlite3", dbFile)
+	// the job workers write on their own connections, so a writer waits for the lock instead of failing right away
+	db, err := sqlx.Open("sqlite3", dbFile+"?_busy_timeout=5000")
 	if err != nil {
 		log.Fatalln(err)
 		return nil
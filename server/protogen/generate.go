@@ -0,0 +1,335 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"guessthesketch/game"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	In    = "in"    // sent by clients to the server
+	Out   = "out"   // sent by the server to clients
+	InOut = "inout" // sent in both directions with the same payload
+)
+
+// describes a single websocket message: the constant for its code, its direction, and the type of its msg field
+type Message struct {
+	Name      string // name of the code constant in the game package
+	Code      int
+	Direction string
+	Payload   reflect.Type // nil for messages without a body
+	Alias     string       // name of the go type alias used for the payload, if any
+}
+
+func payload[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// every message code in the protocol, a test checks this stays in sync with the code constants in the game package
+var Messages = []Message{
+	{Name: "StartCode", Code: game.StartCode, Direction: In},
+	{Name: "TextCode", Code: game.TextCode, Direction: In, Payload: payload[game.TextMsg]()},
+	{Name: "DrawCode", Code: game.DrawCode, Direction: InOut, Payload: payload[game.DrawMsg](), Alias: "DrawMsg"},
+	{Name: "ChatCode", Code: game.ChatCode, Direction: Out, Payload: payload[game.Chat]()},
+	{Name: "FinishCode", Code: game.FinishCode, Direction: Out, Payload: payload[game.FinishMsg]()},
+	{Name: "BeginCode", Code: game.BeginCode, Direction: Out, Payload: payload[game.BeginMsg]()},
+	{Name: "JoinCode", Code: game.JoinCode, Direction: Out, Payload: payload[game.PlayerMsg]()},
+	{Name: "LeaveCode", Code: game.LeaveCode, Direction: Out, Payload: payload[game.PlayerMsg]()},
+	{Name: "TimeoutCode", Code: game.TimeoutCode, Direction: Out},
+	{Name: "SaveCode", Code: game.SaveCode, Direction: In},
+	{Name: "StateCode", Code: game.StateCode, Direction: Out, Payload: payload[game.StateJson]()},
+	{Name: "ErrorCode", Code: game.ErrorCode, Direction: Out, Payload: payload[game.ErrorMsg]()},
+	{Name: "StrokeCode", Code: game.StrokeCode, Direction: In, Payload: payload[game.StrokeMsg]()},
+	{Name: "DrawBatchCode", Code: game.DrawBatchCode, Direction: Out, Payload: payload[game.DrawBatchMsg](), Alias: "DrawBatchMsg"},
+	{Name: "ProtocolCode", Code: game.ProtocolCode, Direction: Out, Payload: payload[game.ProtocolMsg]()},
+	{Name: "ShutdownCode", Code: game.ShutdownCode, Direction: Out},
+	{Name: "ExpiryCode", Code: game.ExpiryCode, Direction: Out, Payload: payload[game.ExpiryMsg]()},
+	{Name: "ExtendCode", Code: game.ExtendCode, Direction: In},
+	{Name: "RematchCode", Code: game.RematchCode, Direction: In, Payload: payload[game.RematchMsg]()},
+	{Name: "VoteCode", Code: game.VoteCode, Direction: Out, Payload: payload[game.VoteMsg]()},
+	{Name: "RestartCode", Code: game.RestartCode, Direction: Out, Payload: payload[game.RestartMsg]()},
+	{Name: "HostCode", Code: game.HostCode, Direction: Out, Payload: payload[game.HostMsg]()},
+	{Name: "TransferCode", Code: game.TransferCode, Direction: In, Payload: payload[game.TransferMsg]()},
+	{Name: "ChooseCode", Code: game.ChooseCode, Direction: Out, Payload: payload[game.ChooseMsg]()},
+	{Name: "WordsCode", Code: game.WordsCode, Direction: Out, Payload: payload[game.WordsMsg]()},
+	{Name: "PickCode", Code: game.PickCode, Direction: In, Payload: payload[game.PickMsg]()},
+	{Name: "WordCode", Code: game.WordCode, Direction: Out, Payload: payload[game.WordMsg]()},
+	{Name: "HintCode", Code: game.HintCode, Direction: Out, Payload: payload[game.HintMsg]()},
+	{Name: "CloseCode", Code: game.CloseCode, Direction: Out, Payload: payload[game.CloseMsg]()},
+	{Name: "TeamCode", Code: game.TeamCode, Direction: In, Payload: payload[game.TeamMsg]()},
+	{Name: "TeamsCode", Code: game.TeamsCode, Direction: Out, Payload: payload[game.TeamsMsg]()},
+	{Name: "SpectatorsCode", Code: game.SpectatorsCode, Direction: Out, Payload: payload[game.SpectatorsMsg]()},
+	{Name: "SpectatorChatCode", Code: game.SpectatorChatCode, Direction: Out, Payload: payload[game.Chat]()},
+	{Name: "RelayCode", Code: game.RelayCode, Direction: Out, Payload: payload[game.RelayMsg]()},
+	{Name: "DescribeCode", Code: game.DescribeCode, Direction: In, Payload: payload[game.DescribeMsg]()},
+	{Name: "DescriptionCode", Code: game.DescriptionCode, Direction: Out, Payload: payload[game.DescriptionMsg]()},
+}
+
+// types that aren't message payloads but are still part of the api clients talk to
+var ExtraTypes = []reflect.Type{
+	payload[game.RoomSettings](),
+}
+
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+type field struct {
+	Name     string
+	Type     reflect.Type
+	Optional bool
+}
+
+// the json fields of a struct in declaration order, following the same rules as encoding/json
+func jsonFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{Name: name, Type: f.Type, Optional: strings.Contains(opts, "omitempty")})
+	}
+	return fields
+}
+
+// collects the named struct types reachable from a type
+func collectStructs(t reflect.Type, structs map[string]reflect.Type) {
+	if t.Implements(textMarshaler) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		collectStructs(t.Elem(), structs)
+	case reflect.Struct:
+		if _, ok := structs[t.Name()]; ok {
+			return
+		}
+		structs[t.Name()] = t
+		for _, f := range jsonFields(t) {
+			collectStructs(f.Type, structs)
+		}
+	}
+}
+
+func reachableStructs() []reflect.Type {
+	structs := make(map[string]reflect.Type)
+	for _, m := range Messages {
+		if m.Payload != nil {
+			collectStructs(m.Payload, structs)
+		}
+	}
+	for _, t := range ExtraTypes {
+		collectStructs(t, structs)
+	}
+
+	names := make([]string, 0, len(structs))
+	for name := range structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	types := make([]reflect.Type, len(names))
+	for i, name := range names {
+		types[i] = structs[name]
+	}
+	return types
+}
+
+var camelBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// converts a go constant name such as DrawBatchCode to the client naming of DRAW_BATCH_CODE
+func screamingCase(name string) string {
+	return strings.ToUpper(camelBoundary.ReplaceAllString(name, "${1}_${2}"))
+}
+
+func tsType(t reflect.Type) string {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return "any"
+	}
+	if t.Implements(textMarshaler) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Pointer:
+		return tsType(t.Elem()) + " | null"
+	case reflect.Slice, reflect.Array:
+		elem := tsType(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return fmt.Sprintf("{ [key: string]: %s }", tsType(t.Elem()))
+	case reflect.Struct:
+		return t.Name()
+	default:
+		return "any"
+	}
+}
+
+// generates the typescript definitions for the protocol
+func GenerateTypeScript() []byte {
+	var b bytes.Buffer
+	b.WriteString("/*\n * Code generated by protogen from the go types in the game package. DO NOT EDIT.\n */\n\n")
+
+	b.WriteString(fmt.Sprintf("export const PROTOCOL_VERSION = %d;\n", game.ProtocolVersion))
+	b.WriteString(fmt.Sprintf("export const MIN_PROTOCOL_VERSION = %d;\n\n", game.MinProtocolVersion))
+
+	b.WriteString(fmt.Sprintf("export const BINARY_FEATURE = %q;\n", game.BinaryFeature))
+	b.WriteString(fmt.Sprintf("export const BATCHING_FEATURE = %q;\n", game.BatchingFeature))
+	b.WriteString(fmt.Sprintf("export const COMPRESSION_FEATURE = %q;\n\n", game.CompressionFeature))
+
+	for _, m := range Messages {
+		msgType := "none"
+		if m.Alias != "" {
+			msgType = m.Alias
+		} else if m.Payload != nil {
+			msgType = tsType(m.Payload)
+		}
+		b.WriteString(fmt.Sprintf("/** %s, msg: %s */\n", m.Direction, msgType))
+		b.WriteString(fmt.Sprintf("export const %s = %d;\n", screamingCase(m.Name), m.Code))
+	}
+	b.WriteString("\n")
+
+	b.WriteString("export interface Payload<T = any> {\n")
+	b.WriteString("    code: number;\n")
+	b.WriteString("    msg: T;\n")
+	b.WriteString("    TraceID?: string;\n")
+	b.WriteString("}\n")
+
+	for _, t := range reachableStructs() {
+		b.WriteString(fmt.Sprintf("\nexport interface %s {\n", t.Name()))
+		for _, f := range jsonFields(t) {
+			optional := ""
+			if f.Optional {
+				optional = "?"
+			}
+			b.WriteString(fmt.Sprintf("    %s%s: %s;\n", f.Name, optional, tsType(f.Type)))
+		}
+		b.WriteString("}\n")
+	}
+
+	// go type aliases are lost to reflection, so the aliased payload names are declared explicitly
+	var aliases bytes.Buffer
+	for _, m := range Messages {
+		if m.Alias != "" {
+			aliases.WriteString(fmt.Sprintf("export type %s = %s;\n", m.Alias, tsType(m.Payload)))
+		}
+	}
+	if aliases.Len() > 0 {
+		b.WriteString("\n")
+		b.Write(aliases.Bytes())
+	}
+
+	return b.Bytes()
+}
+
+type schema = map[string]any
+
+func jsonSchema(t reflect.Type) schema {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return schema{}
+	}
+	if t.Implements(textMarshaler) {
+		return schema{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Pointer:
+		return schema{"oneOf": []schema{{"type": "null"}, jsonSchema(t.Elem())}}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		return schema{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return schema{}
+	}
+}
+
+func structSchema(t reflect.Type) schema {
+	properties := schema{}
+	required := make([]string, 0)
+	for _, f := range jsonFields(t) {
+		properties[f.Name] = jsonSchema(f.Type)
+		if !f.Optional {
+			required = append(required, f.Name)
+		}
+	}
+	return schema{"type": "object", "properties": properties, "required": required}
+}
+
+// generates a json schema document describing every message envelope and the types used within them
+func GenerateSchema() ([]byte, error) {
+	defs := schema{}
+	for _, t := range reachableStructs() {
+		defs[t.Name()] = structSchema(t)
+	}
+
+	messages := make([]schema, 0, len(Messages))
+	for _, m := range Messages {
+		properties := schema{
+			"code":    schema{"const": m.Code},
+			"TraceID": schema{"type": "string"},
+		}
+		required := []string{"code"}
+		if m.Payload != nil {
+			properties["msg"] = jsonSchema(m.Payload)
+			required = append(required, "msg")
+		}
+		messages = append(messages, schema{
+			"title":       m.Name,
+			"description": m.Direction,
+			"type":        "object",
+			"properties":  properties,
+			"required":    required,
+		})
+	}
+
+	doc := schema{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   fmt.Sprintf("Guess the Sketch websocket protocol v%d", game.ProtocolVersion),
+		"oneOf":   messages,
+		"$defs":   defs,
+	}
+	buf, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+// generates the protocol schema and typescript definitions from the go types, run from the server directory with
+// go run ./protogen
+func main() {
+	tsPath := flag.String("ts", "../client/src/websocket/protocol.ts", "output path for the typescript definitions")
+	schemaPath := flag.String("schema", "../client/src/websocket/protocol.schema.json", "output path for the json schema")
+	flag.Parse()
+
+	err := os.WriteFile(*tsPath, GenerateTypeScript(), 0644)
+	if err != nil {
+		log.Fatalf("Failed to write typescript definitions %v", err)
+	}
+
+	schema, err := GenerateSchema()
+	if err != nil {
+		log.Fatalf("Failed to generate json schema %v", err)
+	}
+	err = os.WriteFile(*schemaPath, schema, 0644)
+	if err != nil {
+		log.Fatalf("Failed to write json schema %v", err)
+	}
+
+	log.Printf("Generated %s and %s", *tsPath, *schemaPath)
+}
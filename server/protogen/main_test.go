@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	TsPath     = "../../client/src/websocket/protocol.ts"
+	SchemaPath = "../../client/src/websocket/protocol.schema.json"
+)
+
+// fails when the go types change without regenerating the committed output
+func TestGenerate_UpToDate(t *testing.T) {
+	committedTs, err := os.ReadFile(TsPath)
+	if err != nil {
+		t.Fatalf("Failed to read committed typescript definitions %v", err)
+	}
+	if string(committedTs) != string(GenerateTypeScript()) {
+		t.Fatalf("%s is stale, run go run ./protogen from the server directory", TsPath)
+	}
+
+	schema, err := GenerateSchema()
+	if err != nil {
+		t.Fatalf("Failed to generate json schema %v", err)
+	}
+	committedSchema, err := os.ReadFile(SchemaPath)
+	if err != nil {
+		t.Fatalf("Failed to read committed json schema %v", err)
+	}
+	if string(committedSchema) != string(schema) {
+		t.Fatalf("%s is stale, run go run ./protogen from the server directory", SchemaPath)
+	}
+}
+
+// fails when a message code is added to the game package without describing it in the generator
+func TestMessages_CoverCodes(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, "../game", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse game package %v", err)
+	}
+
+	described := make(map[string]bool)
+	for _, m := range Messages {
+		described[m.Name] = true
+	}
+
+	for _, file := range pkgs["game"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if strings.HasSuffix(name.Name, "Code") && !described[name.Name] {
+						t.Errorf("Message code %s is not described in the protocol generator", name.Name)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package pubsub
+
+import (
+	"log"
+	"sync"
+)
+
+// a message published on a topic, carrying whether its data is binary so the encoding never has to be guessed
+type Message struct {
+	Data       []byte
+	Binary     bool // whether the data is binary rather than text
+	Overflowed bool // marks the last message to a subscriber that fell behind, it carries no data
+}
+
+// delivers messages published on a topic to every channel subscribed to it, possibly on other nodes
+type PubSub interface {
+	Publish(topic string, msg Message) error
+	Subscribe(topic string, ch chan Message) error
+	Unsubscribe(topic string, ch chan Message) error
+}
+
+// a channel subscribed to one or more topics, nothing is delivered to it once it is unsubscribed from every topic so
+// its owner can close it
+type subscription struct {
+	ch         chan Message
+	topics     map[string]bool
+	overflowed bool
+	mu         sync.Mutex // used to synchronize delivery with unsubscribing
+}
+
+// delivers without blocking, a subscriber that doesn't keep up with its topics would otherwise stall every other
+// subscriber on them. a subscriber that missed a message would be left with the wrong state of the room, so once
+// only the last slot of its channel is free it is sent the overflow marker and nothing more
+func (sub *subscription) deliver(topic string, msg Message) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.topics[topic] || sub.overflowed {
+		return
+	}
+	if len(sub.ch)+1 < cap(sub.ch) {
+		select {
+		case sub.ch <- msg:
+			return
+		default:
+		}
+	}
+	log.Printf("Cutting off a subscriber on topic %s that isn't keeping up", topic)
+	sub.overflowed = true
+	select {
+	case sub.ch <- Message{Overflowed: true}:
+	default:
+	}
+}
+
+// the subscriptions of each topic, shared by the implementations to track their local channels
+type topicTable struct {
+	topics map[string]map[chan Message]*subscription
+	subs   map[chan Message]*subscription // the subscription of each channel across its topics
+	mu     sync.Mutex                     // used to synchronize the topics
+}
+
+func newTopicTable() topicTable {
+	return topicTable{
+		topics: make(map[string]map[chan Message]*subscription),
+		subs:   make(map[chan Message]*subscription),
+	}
+}
+
+// returns whether the channel is the first one subscribed to the topic
+func (table *topicTable) add(topic string, ch chan Message) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	subscribers, ok := table.topics[topic]
+	if !ok {
+		subscribers = make(map[chan Message]*subscription)
+		table.topics[topic] = subscribers
+	}
+	sub, exists := table.subs[ch]
+	if !exists {
+		sub = &subscription{ch: ch, topics: make(map[string]bool)}
+		table.subs[ch] = sub
+	}
+	subscribers[ch] = sub
+
+	sub.mu.Lock()
+	sub.topics[topic] = true
+	sub.mu.Unlock()
+	return !ok
+}
+
+// returns whether the channel was the last one subscribed to the topic, nothing on the topic is delivered to the
+// channel once this returns
+func (table *topicTable) remove(topic string, ch chan Message) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	subscribers, ok := table.topics[topic]
+	if !ok {
+		return false
+	}
+	if sub, exists := subscribers[ch]; exists {
+		delete(subscribers, ch)
+		sub.mu.Lock()
+		delete(sub.topics, topic)
+		if len(sub.topics) == 0 {
+			delete(table.subs, ch)
+		}
+		sub.mu.Unlock()
+	}
+	last := len(subscribers) == 0
+	if last {
+		delete(table.topics, topic)
+	}
+	return last
+}
+
+// delivers outside the lock, so a slow subscriber never holds up subscribing and unsubscribing
+func (table *topicTable) deliver(topic string, msg Message) {
+	table.mu.Lock()
+	subs := make([]*subscription, 0, len(table.topics[topic]))
+	for _, sub := range table.topics[topic] {
+		subs = append(subs, sub)
+	}
+	table.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.deliver(topic, msg)
+	}
+}
+
+// in process implementation that delivers before publish returns, so messages from a publisher reach each
+// subscriber in order. subscriber channels must be buffered, a subscriber that fills its channel is cut off
+type MemoryPubSub struct {
+	table topicTable
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{table: newTopicTable()}
+}
+
+func (ps *MemoryPubSub) Publish(topic string, msg Message) error {
+	ps.table.deliver(topic, msg)
+	return nil
+}
+
+func (ps *MemoryPubSub) Subscribe(topic string, ch chan Message) error {
+	ps.table.add(topic, ch)
+	return nil
+}
+
+func (ps *MemoryPubSub) Unsubscribe(topic string, ch chan Message) error {
+	ps.table.remove(topic, ch)
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package pubsub
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryPubSub_PublishSubscribe(t *testing.T) {
+	ps := NewMemoryPubSub()
+
+	ch1 := make(chan Message, 2)
+	ch2 := make(chan Message, 2)
+	_ = ps.Subscribe("topic", ch1)
+	_ = ps.Subscribe("topic", ch2)
+	_ = ps.Subscribe("other", make(chan Message))
+
+	_ = ps.Publish("topic", Message{Data: []byte("hello")})
+	for i, ch := range []chan Message{ch1, ch2} {
+		if msg := <-ch; string(msg.Data) != "hello" {
+			t.Fatalf("Expected subscriber %d to receive the message, got %s", i, string(msg.Data))
+		}
+	}
+
+	_ = ps.Unsubscribe("topic", ch1)
+	_ = ps.Publish("topic", Message{Data: []byte("again")})
+	if len(ch1) != 0 {
+		t.Fatalf("Expected an unsubscribed channel to receive nothing")
+	}
+	if msg := <-ch2; string(msg.Data) != "again" {
+		t.Fatalf("Expected the remaining subscriber to receive the message, got %s", string(msg.Data))
+	}
+}
+
+// testing a subscriber that stopped reading doesn't hold up the other subscribers or unsubscribing, and is cut off
+// on every topic rather than silently missing messages
+func TestMemoryPubSub_SlowSubscriber(t *testing.T) {
+	ps := NewMemoryPubSub()
+
+	stalled := make(chan Message, 2)
+	ch := make(chan Message, 4)
+	_ = ps.Subscribe("topic", stalled)
+	_ = ps.Subscribe("other", stalled)
+	_ = ps.Subscribe("topic", ch)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			_ = ps.Publish("topic", Message{Data: []byte("hello")})
+		}
+		_ = ps.Publish("other", Message{Data: []byte("hello")})
+		_ = ps.Unsubscribe("topic", stalled)
+		_ = ps.Unsubscribe("other", stalled)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected publishing and unsubscribing to not wait on a stalled subscriber")
+	}
+
+	if len(ch) != 3 {
+		t.Fatalf("Expected the subscriber keeping up to receive every message, got %d", len(ch))
+	}
+	// the stalled subscriber is sent the overflow marker in its last slot and nothing after it
+	expMsgs := []Message{{Data: []byte("hello")}, {Overflowed: true}}
+	if len(stalled) != len(expMsgs) {
+		t.Fatalf("Expected the stalled subscriber to be cut off, got %d messages", len(stalled))
+	}
+	for i, expMsg := range expMsgs {
+		if msg := <-stalled; !reflect.DeepEqual(msg, expMsg) {
+			t.Fatalf("Expected message %d to be %v, got %v", i, expMsg, msg)
+		}
+	}
+	// the owner can close the channel once it is unsubscribed
+	close(stalled)
+	_ = ps.Publish("topic", Message{Data: []byte("again")})
+}
+
+// a local stand-in for a redis server that only supports the pubsub commands
+type StandIn struct {
+	listener net.Listener
+	subs     map[string]map[net.Conn]struct{}
+	mu       sync.Mutex
+}
+
+func startStandIn(t *testing.T) *StandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	standIn := &StandIn{listener: listener, subs: make(map[string]map[net.Conn]struct{})}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go standIn.serve(conn)
+		}
+	}()
+	return standIn
+}
+
+func (standIn *StandIn) write(conn net.Conn, buf []byte) {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+	_, _ = conn.Write(buf)
+}
+
+func (standIn *StandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		v, err := readValue(r)
+		if err != nil {
+			return
+		}
+		args := v.([]any)
+		command := string(args[0].([]byte))
+		topic := args[1].([]byte)
+
+		switch command {
+		case "SUBSCRIBE":
+			standIn.mu.Lock()
+			if standIn.subs[string(topic)] == nil {
+				standIn.subs[string(topic)] = make(map[net.Conn]struct{})
+			}
+			standIn.subs[string(topic)][conn] = struct{}{}
+			standIn.mu.Unlock()
+			standIn.write(conn, encodeCommand([]byte("subscribe"), topic, []byte("1")))
+		case "UNSUBSCRIBE":
+			standIn.mu.Lock()
+			delete(standIn.subs[string(topic)], conn)
+			standIn.mu.Unlock()
+			standIn.write(conn, encodeCommand([]byte("unsubscribe"), topic, []byte("0")))
+		case "PUBLISH":
+			standIn.mu.Lock()
+			for sub := range standIn.subs[string(topic)] {
+				_, _ = sub.Write(encodeCommand([]byte("message"), topic, args[2].([]byte)))
+			}
+			standIn.mu.Unlock()
+			standIn.write(conn, []byte(":1\r\n"))
+		}
+	}
+}
+
+func TestRedisPubSub_AcrossNodes(t *testing.T) {
+	standIn := startStandIn(t)
+
+	// each node has its own connection to the server, as separate server instances would
+	node1, err := DialRedis(standIn.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer node1.Close()
+	node2, err := DialRedis(standIn.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer node2.Close()
+
+	ch1 := make(chan Message, 2)
+	ch2 := make(chan Message, 2)
+	// a subscription is confirmed before it returns, so nothing published after it is missed
+	if err = node1.Subscribe("room:123:json", ch1); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err = node2.Subscribe("room:123:json", ch2); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the message is received with the encoding it was published with
+	binaryMsg := Message{Data: []byte{3, 0, 1, '\r', '\n', 255}, Binary: true}
+	if err = node1.Publish("room:123:json", binaryMsg); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for i, ch := range []chan Message{ch1, ch2} {
+		select {
+		case msg := <-ch:
+			if !reflect.DeepEqual(msg, binaryMsg) {
+				t.Fatalf("Expected subscriber on node %d to receive %v, got %v", i+1, binaryMsg, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Subscriber on node %d didn't receive the published message", i+1)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package pubsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long a subscribe waits for the server to confirm it, messages published before the confirmation may be missed
+const SubscribeTimeout = 5 * time.Second
+
+// networked implementation speaking the redis protocol, so subscribers on every node connected to the same redis
+// server receive the messages published by any node
+type RedisPubSub struct {
+	pubConn   net.Conn
+	pubReader *bufio.Reader
+	pubMu     sync.Mutex // used to pair each publish command with its reply
+
+	subConn  net.Conn
+	subMu    sync.Mutex      // used to synchronize subscribe commands with the table and their confirmations
+	confirms []chan struct{} // closed in order as the server confirms each subscribe command
+
+	table topicTable // the local channels subscribed to each topic
+}
+
+// connects to a redis server, a connection in subscribe mode can't publish so two connections are opened
+func DialRedis(addr string) (*RedisPubSub, error) {
+	pubConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	subConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		_ = pubConn.Close()
+		return nil, err
+	}
+
+	ps := &RedisPubSub{
+		pubConn:   pubConn,
+		pubReader: bufio.NewReader(pubConn),
+		subConn:   subConn,
+		table:     newTopicTable(),
+	}
+	go ps.listen(bufio.NewReader(subConn))
+	return ps, nil
+}
+
+func (ps *RedisPubSub) Close() error {
+	errPub := ps.pubConn.Close()
+	errSub := ps.subConn.Close()
+	return errors.Join(errPub, errSub)
+}
+
+// encodes a command as a resp array of bulk strings
+func encodeCommand(args ...[]byte) []byte {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(arg))...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("Malformed resp line")
+	}
+	return line[:len(line)-2], nil
+}
+
+// reads a single resp value: simple strings, errors, integers, bulk strings or arrays of them
+func readValue(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 1 {
+		return nil, errors.New("Empty resp line")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		values := make([]any, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := readValue(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("Unknown resp type %c", line[0])
+	}
+}
+
+// redis messages are only bytes, so the message is prefixed with whether it is binary
+func encodeMessage(msg Message) []byte {
+	kind := byte('t')
+	if msg.Binary {
+		kind = 'b'
+	}
+	return append([]byte{kind}, msg.Data...)
+}
+
+func decodeMessage(buf []byte) (Message, error) {
+	if len(buf) < 1 || (buf[0] != 't' && buf[0] != 'b') {
+		return Message{}, errors.New("Malformed pubsub message")
+	}
+	return Message{Data: buf[1:], Binary: buf[0] == 'b'}, nil
+}
+
+func (ps *RedisPubSub) Publish(topic string, msg Message) error {
+	ps.pubMu.Lock()
+	defer ps.pubMu.Unlock()
+
+	_, err := ps.pubConn.Write(encodeCommand([]byte("PUBLISH"), []byte(topic), encodeMessage(msg)))
+	if err != nil {
+		return err
+	}
+	_, err = readValue(ps.pubReader)
+	return err
+}
+
+func (ps *RedisPubSub) writeSub(command string, topic string) error {
+	_, err := ps.subConn.Write(encodeCommand([]byte(command), []byte(topic)))
+	return err
+}
+
+// returns once the server confirmed the subscription, so every message published after it reaches the channel
+func (ps *RedisPubSub) Subscribe(topic string, ch chan Message) error {
+	confirmed := make(chan struct{})
+
+	ps.subMu.Lock()
+	ps.table.add(topic, ch)
+	// the server confirms a subscribe to a topic this node is already subscribed to as well, so each channel waits
+	// for its own confirmation instead of racing the subscribe of the first channel
+	err := ps.writeSub("SUBSCRIBE", topic)
+	if err == nil {
+		ps.confirms = append(ps.confirms, confirmed)
+	}
+	ps.subMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-confirmed:
+		return nil
+	case <-time.After(SubscribeTimeout):
+		return fmt.Errorf("Redis didn't confirm the subscription to %s", topic)
+	}
+}
+
+func (ps *RedisPubSub) Unsubscribe(topic string, ch chan Message) error {
+	ps.subMu.Lock()
+	defer ps.subMu.Unlock()
+
+	// only the last local channel on a topic needs the server to unsubscribe this node
+	if ps.table.remove(topic, ch) {
+		return ps.writeSub("UNSUBSCRIBE", topic)
+	}
+	return nil
+}
+
+// the server confirms subscribe commands in the order they were sent
+func (ps *RedisPubSub) confirm() {
+	ps.subMu.Lock()
+	defer ps.subMu.Unlock()
+
+	if len(ps.confirms) > 0 {
+		close(ps.confirms[0])
+		ps.confirms = ps.confirms[1:]
+	}
+}
+
+// reads pushed messages from the subscribe connection and delivers them to the local channels on their topic
+func (ps *RedisPubSub) listen(r *bufio.Reader) {
+	for {
+		v, err := readValue(r)
+		if err != nil {
+			log.Printf("Redis subscription closed with err %v", err)
+			return
+		}
+
+		push, ok := v.([]any)
+		if !ok || len(push) != 3 {
+			continue
+		}
+		kind, _ := push[0].([]byte)
+		topic, _ := push[1].([]byte)
+		buf, _ := push[2].([]byte)
+		if string(kind) == "subscribe" {
+			ps.confirm()
+		}
+		if string(kind) != "message" {
+			// confirmations of subscribe and unsubscribe commands
+			continue
+		}
+
+		msg, err := decodeMessage(buf)
+		if err != nil {
+			log.Printf("Dropping message on topic %s: %v", string(topic), err)
+			continue
+		}
+		ps.table.deliver(string(topic), msg)
+	}
+}
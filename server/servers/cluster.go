@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package servers
+
+import (
+	"crypto/subtle"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"guessthesketch/cluster"
+	"guessthesketch/game"
+	"guessthesketch/pubsub"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// serves the endpoints other nodes in the cluster use to exchange the room directory and forward subscribers
+type ClusterServer struct {
+	upgrade     websocket.Upgrader
+	brokerage   *cluster.ClusterBrokerage
+	roomsServer *RoomsServer
+	secret      string
+}
+
+func NewClusterServer(brokerage *cluster.ClusterBrokerage, roomsServer *RoomsServer, secret string) *ClusterServer {
+	return &ClusterServer{
+		upgrade:     CreateUpgrade(),
+		brokerage:   brokerage,
+		roomsServer: roomsServer,
+		secret:      secret,
+	}
+}
+
+// an empty secret never authenticates a peer, even if the node was started without one
+func (server *ClusterServer) isPeer(r *http.Request) bool {
+	secret := r.Header.Get(cluster.SecretHeader)
+	if secret == "" || server.secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(server.secret)) == 1
+}
+
+func (server *ClusterServer) Rooms(w http.ResponseWriter, r *http.Request) {
+	if !server.isPeer(r) {
+		WriteError(w, http.StatusForbidden, "Only nodes in the cluster can read the room directory")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	WriteJson(w, server.brokerage.LocalCodes())
+}
+
+// joins a subscriber forwarded by another node, the forwarding node already authenticated the player and
+// negotiated the protocol with the client, and subscribed the client to the room's topics if the nodes share a pubsub
+func (server *ClusterServer) Join(w http.ResponseWriter, r *http.Request) {
+	if !server.isPeer(r) {
+		WriteError(w, http.StatusForbidden, "Only nodes in the cluster can forward subscribers")
+		return
+	}
+
+	query := r.URL.Query()
+	code := query.Get("code")
+
+	id, err := uuid.Parse(query.Get("id"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Forwarded player must have a valid id")
+		return
+	}
+	player := game.Player{ID: id, Name: query.Get("name")}
+
+	version, err := strconv.Atoi(query.Get("version"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Forwarded protocol must have a version")
+		return
+	}
+	var features []string
+	if featuresStr := query.Get("features"); featuresStr != "" {
+		features = strings.Split(featuresStr, ",")
+	}
+	protocol, err := game.NegotiateProtocol(version, features)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !server.brokerage.Owns(code) {
+		WriteError(w, http.StatusMisdirectedRequest, "Room code is not owned by this node")
+		return
+	}
+	room := server.brokerage.Get(code)
+	if room == nil {
+		WriteError(w, http.StatusNotFound, "Cannot find room for provided code")
+		return
+	}
+
+	ws, err := server.upgrade.Upgrade(w, r, nil)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Failed to upgrade to websocket")
+		return
+	}
+
+	subscriber := make(chan pubsub.Message, SubscriberBuffer)
+	spectator := query.Get("spectate") == "true"
+	subscriberID := query.Get("subscriber")
+	room.Join(game.SubscriberMsg{
+		Subscriber: subscriber,
+		Player:     player,
+		Protocol:   protocol,
+		Spectator:  spectator,
+		ID:         subscriberID,
+		Remote:     subscriberID != "",
+	})
+
+	log.Printf("Joined forwarded subscriber to room %s with name %s and id %s", code, player.Name, player.ID)
+
+	go server.roomsServer.subscriberListener(ws, subscriber)
+	go server.roomsServer.socketListener(ws, room, subscriber)
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) Joseph Prichard 2024
+ */
+
+package servers
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"guessthesketch/cluster"
+	"guessthesketch/game"
+	"guessthesketch/pubsub"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestNode struct {
+	server    *httptest.Server
+	brokerage *cluster.ClusterBrokerage
+}
+
+// starts several nodes in the process, each with its own brokerage, all sharing a ring
+func startTestNodes(t *testing.T, n int) []TestNode {
+	return startTestNodesWithPubSub(t, n, nil)
+}
+
+// starts several nodes sharing the pubsub, as nodes connected to the same redis server would
+func startTestNodesWithPubSub(t *testing.T, n int, ps pubsub.PubSub) []TestNode {
+	muxes := make([]*http.ServeMux, n)
+	nodes := make([]TestNode, n)
+	addrs := make([]string, n)
+	for i := range nodes {
+		muxes[i] = http.NewServeMux()
+		nodes[i].server = httptest.NewServer(muxes[i])
+		addrs[i] = nodes[i].server.URL
+		t.Cleanup(nodes[i].server.Close)
+	}
+
+	for i := range nodes {
+		store := game.NewBrokerStore(time.Minute)
+		brokerage := cluster.NewClusterBrokerageWithPubSub(store, addrs[i], addrs, "secret", 0, ps)
+		authenticator := &StubAuthenticator{testPlayer: GuestUser()}
+		roomsServer := NewRoomsServerWithPubSub(brokerage, authenticator, &FakeHandler{}, []string{"Word"}, ps)
+		clusterServer := NewClusterServer(brokerage, roomsServer, "secret")
+
+		muxes[i].HandleFunc("/api/rooms/create", roomsServer.CreateRoom)
+		muxes[i].HandleFunc("/api/rooms/join", roomsServer.JoinRoom)
+		muxes[i].HandleFunc("/api/cluster/rooms", clusterServer.Rooms)
+		muxes[i].HandleFunc("/api/cluster/join", clusterServer.Join)
+		nodes[i].brokerage = brokerage
+	}
+	return nodes
+}
+
+func TestCluster_JoinRemoteRoom(t *testing.T) {
+	nodes := startTestNodes(t, 3)
+
+	body, err := PostJson(nodes[0].server.URL+"/api/rooms/create", game.RoomSettings{IsPublic: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var roomResp RoomCodeResp
+	if err = json.Unmarshal(body, &roomResp); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !nodes[0].brokerage.Owns(roomResp.Code) {
+		t.Fatalf("Expected a room created on a node to be owned by that node")
+	}
+
+	// join through another node, which forwards the subscriber to the owner
+	u := "ws" + strings.TrimPrefix(nodes[1].server.URL, "http") + "/api/rooms/join?code=" + roomResp.Code
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ws.Close()
+	readUntilState(t, ws)
+
+	input := game.InputPayload[game.TextMsg]{
+		Code: game.TextCode,
+		Msg:  game.TextMsg{Text: "Hello 123"},
+	}
+	bufIn, _ := json.Marshal(input)
+	if err = ws.WriteMessage(websocket.TextMessage, bufIn); err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, bufOut, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var payload game.OutputPayload[game.Chat]
+	if err = json.Unmarshal(bufOut, &payload); err != nil || payload.Code != game.ChatCode || payload.Msg.Text != "Hello 123" {
+		t.Fatalf("Expected the chat message to be forwarded back from the owner, got %s", string(bufOut))
+	}
+
+	// every node lists the public room once the directory is exchanged
+	for i, node := range nodes {
+		node.brokerage.Sync()
+		if codes := node.brokerage.Codes(0, 20); !reflect.DeepEqual(codes, []string{roomResp.Code}) {
+			t.Fatalf("Expected node %d to list codes %v, got %v", i, []string{roomResp.Code}, codes)
+		}
+	}
+}
+
+// testing a subscriber on another node receives the room's frames through the shared pubsub in the order they were sent
+func TestCluster_SharedPubSub(t *testing.T) {
+	nodes := startTestNodesWithPubSub(t, 2, pubsub.NewMemoryPubSub())
+
+	body, err := PostJson(nodes[0].server.URL+"/api/rooms/create", game.RoomSettings{IsPublic: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var roomResp RoomCodeResp
+	if err = json.Unmarshal(body, &roomResp); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	dial := func(node TestNode) *websocket.Conn {
+		u := "ws" + strings.TrimPrefix(node.server.URL, "http") + "/api/rooms/join?code=" + roomResp.Code
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		t.Cleanup(func() { _ = ws.Close() })
+		return ws
+	}
+	readCode := func(ws *websocket.Conn) int {
+		_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+		_, buf, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var payload game.OutputPayload[json.RawMessage]
+		if err = json.Unmarshal(buf, &payload); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return payload.Code
+	}
+
+	host := dial(nodes[0])
+	readUntilState(t, host)
+
+	// the joining player receives its own join before the state sent only to it
+	guest := dial(nodes[1])
+	for _, expCode := range []int{game.JoinCode, game.StateCode} {
+		if code := readCode(guest); code != expCode {
+			t.Fatalf("Expected the remote player to receive code %d, got %d", expCode, code)
+		}
+	}
+	if code := readCode(host); code != game.JoinCode {
+		t.Fatalf("Expected the host to receive the join of the remote player, got %d", code)
+	}
+
+	input := game.InputPayload[game.TextMsg]{Code: game.TextCode, Msg: game.TextMsg{Text: "Hello 123"}}
+	bufIn, _ := json.Marshal(input)
+	if err = guest.WriteMessage(websocket.TextMessage, bufIn); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, ws := range []*websocket.Conn{guest, host} {
+		if code := readCode(ws); code != game.ChatCode {
+			t.Fatalf("Expected every player to receive the chat, got %d", code)
+		}
+	}
+}
+
+func TestCluster_RejectsMissingSecret(t *testing.T) {
+	nodes := startTestNodes(t, 1)
+	url := nodes[0].server.URL
+
+	tests := []struct {
+		path   string
+		secret string
+	}{
+		{path: "/api/cluster/join?code=123&id=" + uuid.NewString() + "&name=host&version=1"},
+		{path: "/api/cluster/rooms"},
+		{path: "/api/cluster/rooms", secret: "wrong"},
+	}
+	for i, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, url+test.path, nil)
+		if test.secret != "" {
+			req.Header.Set(cluster.SecretHeader, test.secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected request %d to be forbidden, got %d", i, resp.StatusCode)
+		}
+	}
+
+	// a node started without a secret doesn't accept an empty one
+	server := NewClusterServer(nodes[0].brokerage, nil, "")
+	req := httptest.NewRequest(http.MethodGet, "/api/cluster/rooms", nil)
+	if server.isPeer(req) {
+		t.Fatalf("Expected an empty secret to never authenticate a peer")
+	}
+}
+
+func TestCluster_JoinMissingRemoteRoom(t *testing.T) {
+	nodes := startTestNodes(t, 2)
+
+	// find a code owned by the second node, which has no rooms
+	code := ""
+	for code == "" || nodes[0].brokerage.Owns(code) {
+		code, _ = HexCode(8)
+	}
+
+	u := "ws" + strings.TrimPrefix(nodes[0].server.URL, "http") + "/api/rooms/join?code=" + code
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ws.Close()
+
+	_, buf, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var payload game.OutputPayload[game.ErrorMsg]
+	if err = json.Unmarshal(buf, &payload); err != nil || payload.Code != game.ErrorCode {
+		t.Fatalf("Expected an error for a room missing on its owner, got %s", string(buf))
+	}
+	if _, _, err = ws.ReadMessage(); err == nil {
+		t.Fatalf("Expected the socket to be closed after the error")
+	}
+}
@@ -70,8 +70,9 @@ func EnableCors(w *http.ResponseWriter) {
 
 func CreateUpgrade() websocket.Upgrader {
 	upgrade := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: true, // negotiates permessage-deflate with clients that support it
 	}
 	upgrade.CheckOrigin = func(r *http.Request) bool {
 		return true
@@ -5,35 +5,62 @@
 package servers
 
 import (
+	"compress/flate"
+	"context"
 	crand "crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/jmoiron/sqlx"
 	"guessthesketch/database"
 	"guessthesketch/game"
+	"guessthesketch/pubsub"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
+// messages queued for a socket, enough for a few strokes sent as single draws to clients that don't batch them. a
+// subscriber that falls further behind is cut off and its socket closed, so the client reconnects to a fresh state
+const SubscriberBuffer = 4 * game.MaxStrokePoints
+
+// codes generated before giving up on finding one this server owns, a node owning any real share of the codes finds
+// one well within this
+const MaxCodeAttempts = 1000
+
 type RoomsServer struct {
 	upgrade       websocket.Upgrader
 	brokerage     game.Brokerage
 	authenticator Authenticator
 	handler       game.EventHandler
 	gameWordBank  []string
+	pubsub        pubsub.PubSub // shared by every room created on this server, nil gives each room its own
+	draining      atomic.Bool   // set once the server is shutting down and no longer accepts rooms or players
 }
 
 func NewRoomsServer(
 	brokerage game.Brokerage, authenticator Authenticator,
 	handler game.EventHandler, gameWordBank []string) *RoomsServer {
 
+	return NewRoomsServerWithPubSub(brokerage, authenticator, handler, gameWordBank, nil)
+}
+
+func NewRoomsServerWithPubSub(
+	brokerage game.Brokerage, authenticator Authenticator,
+	handler game.EventHandler, gameWordBank []string, ps pubsub.PubSub) *RoomsServer {
+
 	return &RoomsServer{
 		upgrade:       CreateUpgrade(),
 		brokerage:     brokerage,
 		authenticator: authenticator,
 		handler:       handler,
 		gameWordBank:  gameWordBank,
+		pubsub:        ps,
 	}
 }
 
@@ -72,12 +99,38 @@ type RoomCodeResp struct {
 	Settings game.RoomSettings `json:"settings"`
 }
 
+// generates a room code this server owns, brokerages spread across nodes only own some codes
+func (server *RoomsServer) generateCode() (string, error) {
+	partitioned, isPartitioned := server.brokerage.(game.Partitioned)
+	for i := 0; i < MaxCodeAttempts; i++ {
+		code, err := HexCode(8)
+		if err != nil {
+			return "", err
+		}
+		if !isPartitioned || partitioned.Owns(code) {
+			return code, nil
+		}
+	}
+	return "", errors.New("Failed to generate a code owned by this server")
+}
+
+// stops the server from accepting new rooms and players, rooms that already exist are left to be shut down
+func (server *RoomsServer) Drain() {
+	server.draining.Store(true)
+}
+
 func (server *RoomsServer) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	EnableCors(&w)
 
+	if server.draining.Load() {
+		WriteError(w, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+
 	// generate a code, create a room, start it, then store it in the map
-	code, err := HexCode(8)
+	code, err := server.generateCode()
 	if err != nil {
+		log.Printf("Failed to generate a room code: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to generate a valid room code")
 		return
 	}
@@ -99,7 +152,12 @@ func (server *RoomsServer) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	initialState := game.NewGameState(code, settings)
-	room := game.NewRoom(initialState, settings.IsPublic, server.handler)
+	var room *game.Room
+	if server.pubsub != nil {
+		room = game.NewRoomWithPubSub(initialState, settings.IsPublic, server.handler, server.pubsub)
+	} else {
+		room = game.NewRoom(initialState, settings.IsPublic, server.handler)
+	}
 	go room.Start()
 	server.brokerage.Set(code, room)
 
@@ -116,6 +174,13 @@ func (server *RoomsServer) JoinRoom(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	code := query.Get("code")
 	token := query.Get("token")
+	// spectators watch the game without playing in it
+	spectator := query.Get("spectate") == "true"
+
+	if server.draining.Load() {
+		WriteError(w, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
 
 	player := server.authenticator.GetPlayer(token)
 
@@ -130,10 +195,20 @@ func (server *RoomsServer) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, http.StatusInternalServerError, "Failed to upgrade to websocket")
 		return
 	}
+	// most frames are small draw messages, so favor speed over ratio when permessage-deflate was negotiated
+	if err = ws.SetCompressionLevel(flate.BestSpeed); err != nil {
+		log.Printf("Failed to set the compression level for room %s: %v", code, err)
+	}
+
+	protocol, err := handshake(ws, query)
+	if err != nil {
+		log.Printf("Rejected client protocol for room %s: %s", code, err.Error())
+		return
+	}
 
 	// create a new subscription channel and join the room with it
-	subscriber := make(chan []byte)
-	room.Join(game.SubscriberMsg{Subscriber: subscriber, Player: player})
+	subscriber := make(chan pubsub.Message, SubscriberBuffer)
+	room.Join(game.SubscriberMsg{Subscriber: subscriber, Player: player, Protocol: protocol, Spectator: spectator})
 
 	log.Printf("Joined room %s with name %s and id %s", code, player.Name, player.ID)
 
@@ -141,8 +216,75 @@ func (server *RoomsServer) JoinRoom(w http.ResponseWriter, r *http.Request) {
 	go server.socketListener(ws, room, subscriber)
 }
 
+// negotiates the protocol announced in the join query, versioned clients are told which features were accepted
+// and incompatible clients receive an error before the socket is closed
+func handshake(ws *websocket.Conn, query url.Values) (game.Protocol, error) {
+	// clients that don't announce a version predate versioning and speak the original json protocol
+	version := game.MinProtocolVersion
+	versionStr := query.Get("version")
+	if versionStr != "" {
+		parsedVersion, err := strconv.Atoi(versionStr)
+		if err != nil {
+			parsedVersion = -1
+		}
+		version = parsedVersion
+	}
+
+	var features []string
+	if featuresStr := query.Get("features"); featuresStr != "" {
+		features = strings.Split(featuresStr, ",")
+	}
+
+	protocol, err := game.NegotiateProtocol(version, features)
+	if err != nil {
+		closeWithError(ws, err.Error())
+		return game.Protocol{}, err
+	}
+
+	if versionStr != "" {
+		resp, err := game.CreateProtocolResponse(protocol)
+		if err != nil {
+			closeWithError(ws, err.Error())
+			return game.Protocol{}, err
+		}
+		if err = ws.WriteMessage(websocket.TextMessage, resp); err != nil {
+			_ = ws.Close()
+			return game.Protocol{}, err
+		}
+	}
+	return protocol, nil
+}
+
+func closeWithError(ws *websocket.Conn, errorDesc string) {
+	defer ws.Close()
+
+	resp := ErrorResp{Code: game.ErrorCode, Status: http.StatusBadRequest, ErrorDesc: errorDesc}
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Failed to serialize error for ws message")
+		return
+	}
+	_ = ws.WriteMessage(websocket.TextMessage, buf)
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseProtocolError, truncateReason(errorDesc))
+	_ = ws.WriteMessage(websocket.CloseMessage, closeMsg)
+}
+
+// close reasons are limited to 123 bytes by the websocket spec, a longer reason is cut before the character crossing
+// the limit so the reason stays valid utf-8
+func truncateReason(reason string) string {
+	if len(reason) <= 123 {
+		return reason
+	}
+	n := 123
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
 // reads messages from socket and sends them to room
-func (server *RoomsServer) socketListener(ws *websocket.Conn, room game.Broker, subscriber chan []byte) {
+func (server *RoomsServer) socketListener(ws *websocket.Conn, room game.Broker, subscriber chan pubsub.Message) {
 	defer func() {
 		// unsubscribes from the room when the websocket is closed
 		room.Leave(subscriber)
@@ -153,19 +295,20 @@ func (server *RoomsServer) socketListener(ws *websocket.Conn, room game.Broker,
 		}
 	}()
 	for {
-		_, buf, err := ws.ReadMessage()
+		messageType, buf, err := ws.ReadMessage()
 		if err != nil {
 			log.Printf("Client closed connection with err %s", err.Error())
 			return
 		}
 		// read any message from the socket and broadcast it to the room
-		log.Println("Receiving message", string(buf))
-		room.SendMessage(game.SentMsg{Message: buf, Sender: subscriber})
+		log.Printf("Receiving message of %d bytes", len(buf))
+		binary := messageType == websocket.BinaryMessage
+		room.SendMessage(game.SentMsg{Message: buf, Binary: binary, Sender: subscriber})
 	}
 }
 
 // reads messages from a subscribed channel and sends them to socket
-func (server *RoomsServer) subscriberListener(ws *websocket.Conn, subscriber chan []byte) {
+func (server *RoomsServer) subscriberListener(ws *websocket.Conn, subscriber chan pubsub.Message) {
 	defer func() {
 		// closes the websocket connection when the subscriber is informed no more messages will be sent
 		log.Println("Subscriber channel was closed")
@@ -175,36 +318,81 @@ func (server *RoomsServer) subscriberListener(ws *websocket.Conn, subscriber cha
 		}
 	}()
 	for resp := range subscriber {
-		// read values from channel and write back to socket
-		log.Println("Sending message", string(resp))
-		err := ws.WriteMessage(websocket.TextMessage, resp)
+		// the subscriber missed messages, the client can only recover them by reconnecting
+		if resp.Overflowed {
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Fell behind the room")
+			_ = ws.WriteMessage(websocket.CloseMessage, closeMsg)
+			break
+		}
+		// read values from channel and write back to socket, using the frame type the room encoded
+		messageType := websocket.TextMessage
+		if resp.Binary {
+			messageType = websocket.BinaryMessage
+		}
+		log.Printf("Sending message of %d bytes", len(resp.Data))
+		err := ws.WriteMessage(messageType, resp.Data)
 		if err != nil {
 			log.Printf("Error writing message %s", err)
-			return
+			break
 		}
 	}
+	// closing the socket makes the socket listener leave the room, the channel is drained until the room closes it
+	// so nothing sending to it is held up by a dead socket
+	_ = ws.Close()
+	for range subscriber {
+	}
 }
 
 type RoomServer struct {
-	db *sqlx.DB
+	jobs               *database.JobQueue // persists results and snapshots, retrying writes that fail
+	SnapshotOnShutdown bool               // save the canvas of games interrupted by a shutdown
+}
+
+func NewRoomServer(jobs *database.JobQueue) *RoomServer {
+	// each job writes in the transaction that finishes it, so a job retried after a crash never writes twice
+	jobs.Handle(database.UpdateStatsJob, func(tx *sqlx.Tx, payload []byte) error {
+		var results []game.GameResult
+		if err := json.Unmarshal(payload, &results); err != nil {
+			return err
+		}
+		return database.UpdateStatsTx(tx, results)
+	})
+	jobs.Handle(database.SaveSnapshotJob, func(tx *sqlx.Tx, payload []byte) error {
+		var snap game.Snapshot
+		if err := json.Unmarshal(payload, &snap); err != nil {
+			return err
+		}
+		return database.SaveSnapshot(tx, snap)
+	})
+	return &RoomServer{jobs: jobs}
 }
 
-func NewRoomServer(db *sqlx.DB) *RoomServer {
-	return &RoomServer{db}
+func (server *RoomServer) DoShutdown(results []game.GameResult) {
+	// update the stats in the background, the job is retried until the update succeeds
+	err := server.jobs.Enqueue(database.UpdateStatsJob, results)
+	if err != nil {
+		log.Printf("Failed to enqueue the stats update for %d results: %v", len(results), err)
+	}
 }
 
-func (server RoomServer) DoShutdown(results []game.GameResult) {
-	// perform the batch update stats in the background (ignoring the error)
-	go func(results []game.GameResult) {
-		_ = database.UpdateStats(server.db, results)
-	}(results)
+func (server *RoomServer) DoCapture(snap game.Snapshot) {
+	// save the snapshot in the background, the job is retried until the insert succeeds
+	err := server.jobs.Enqueue(database.SaveSnapshotJob, snap)
+	if err != nil {
+		log.Printf("Failed to enqueue the snapshot: %v", err)
+	}
 }
 
-func (server RoomServer) DoCapture(snap game.Snapshot) {
-	// perform a capture operation in the background (ignoring the error)
-	go func(snap game.Snapshot) {
-		_ = database.SaveSnapshot(server.db, snap)
-	}(snap)
+func (server *RoomServer) DoInterrupt(results []game.GameResult, snap game.Snapshot) {
+	server.DoShutdown(results)
+	if server.SnapshotOnShutdown && snap.Canvas != "" {
+		server.DoCapture(snap)
+	}
 }
 
-func (server RoomServer) OnTermination() {}
+func (server *RoomServer) OnTermination() {}
+
+// runs the background writes that are ready, or gives up once the context is done
+func (server *RoomServer) Flush(ctx context.Context) error {
+	return server.jobs.Drain(ctx)
+}
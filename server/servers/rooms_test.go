@@ -8,11 +8,14 @@ import (
 	"encoding/json"
 	"github.com/gorilla/websocket"
 	"guessthesketch/game"
+	"guessthesketch/pubsub"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 // stub implementation of a brokerage that only stores a single broker
@@ -53,6 +56,8 @@ func (fake FakeHandler) DoShutdown(_ []game.GameResult) {}
 
 func (fake FakeHandler) DoCapture(_ game.Snapshot) {}
 
+func (fake FakeHandler) DoInterrupt(_ []game.GameResult, _ game.Snapshot) {}
+
 func (fake FakeHandler) OnTermination() {}
 
 // e2e tests for the websocket server
@@ -79,8 +84,54 @@ func TestRoomsServer_CreateRoom(t *testing.T) {
 	}
 }
 
+func TestRoomsServer_CreateRoomWhileDraining(t *testing.T) {
+	roomsServer := NewRoomsServer(&StubBrokerage{}, &StubAuthenticator{}, &FakeHandler{}, []string{})
+	roomsServer.Drain()
+
+	r := httptest.NewRequest("", "/", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+
+	roomsServer.CreateRoom(w, r)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected create room to be rejected while draining, got %d", resp.StatusCode)
+	}
+}
+
+// stub implementation of a brokerage owning none of the codes, like a node missing from its own ring
+type UnownedBrokerage struct {
+	StubBrokerage
+}
+
+func (stub *UnownedBrokerage) Owns(_ string) bool {
+	return false
+}
+
+func TestRoomsServer_CreateRoomWithoutOwnedCodes(t *testing.T) {
+	roomsServer := NewRoomsServer(&UnownedBrokerage{}, &StubAuthenticator{}, &FakeHandler{}, []string{})
+
+	r := httptest.NewRequest("", "/", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+
+	roomsServer.CreateRoom(w, r)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected create room to give up on finding an owned code, got %d", resp.StatusCode)
+	}
+}
+
 func beforeTestJoinRoom(t *testing.T, initialState game.GameState) (*httptest.Server, *websocket.Conn, game.Player) {
-	testRoom := game.NewRoom(initialState, true, &FakeHandler{})
+	return beforeTestJoinRoomWithQuery(t, initialState, "")
+}
+
+func beforeTestJoinRoomWithQuery(t *testing.T, initialState game.GameState, query string) (*httptest.Server, *websocket.Conn, game.Player) {
+	return beforeTestJoinRoomWithClock(t, initialState, query, game.RealClock{})
+}
+
+func beforeTestJoinRoomWithClock(t *testing.T, initialState game.GameState, query string, clock game.Clock) (*httptest.Server, *websocket.Conn, game.Player) {
+	testRoom := game.NewRoomWithClock(initialState, true, &FakeHandler{}, pubsub.NewMemoryPubSub(), clock)
 	mockRooms := StubBrokerage{}
 	go testRoom.Start()
 	mockRooms.Set(initialState.Code(), testRoom)
@@ -90,25 +141,41 @@ func beforeTestJoinRoom(t *testing.T, initialState game.GameState) (*httptest.Se
 
 	s := httptest.NewServer(http.HandlerFunc(roomsServer.JoinRoom))
 
-	u := "ws" + strings.TrimPrefix(s.URL, "http") + "?code=" + initialState.Code()
+	u := "ws" + strings.TrimPrefix(s.URL, "http") + "?code=" + initialState.Code() + query
 	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
 
-	// check the first two messages end when joining first
-	_, _, err = ws.ReadMessage()
-	if err != nil {
-		t.Fatalf("%v", err)
-	}
-	_, _, err = ws.ReadMessage()
-	if err != nil {
-		t.Fatalf("%v", err)
-	}
+	// check the handshake, join and state messages are sent when joining first
+	readUntilState(t, ws)
 
 	return s, ws, player
 }
 
+// reads messages from the websocket until the state message for the joining subscriber is received
+func readUntilState(t *testing.T, ws *websocket.Conn) {
+	for {
+		messageType, buf, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if messageType == websocket.BinaryMessage {
+			if buf[0] == game.StateCode {
+				return
+			}
+			continue
+		}
+		var payload game.OutputPayload[json.RawMessage]
+		if err = json.Unmarshal(buf, &payload); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if payload.Code == game.StateCode {
+			return
+		}
+	}
+}
+
 // runs a test for a message with a particular input and expected output against the websocket connection
 func runTestMessage[I any, O any](t *testing.T, ws *websocket.Conn,
 	input game.InputPayload[I], expected game.OutputPayload[O]) {
@@ -137,6 +204,7 @@ func MockSettings(word string) game.RoomSettings {
 	var settings game.RoomSettings
 	game.SettingsWithDefaults(&settings)
 	settings.SharedWordBank = []string{word}
+	settings.WordChoices = 1
 	return settings
 }
 
@@ -160,9 +228,11 @@ func TestRoomsServer_ChatMessage(t *testing.T) {
 
 func TestRoomServer_StartMessage(t *testing.T) {
 	word := "Word"
-	initialState := game.NewGameState("123abc", MockSettings(word))
+	settings := MockSettings(word)
+	initialState := game.NewGameState("123abc", settings)
+	clock := game.NewFakeClock(time.UnixMilli(1700000000000))
 
-	s, ws, _ := beforeTestJoinRoom(t, initialState)
+	s, ws, _ := beforeTestJoinRoomWithClock(t, initialState, "", clock)
 	defer s.Close()
 	defer ws.Close()
 
@@ -174,6 +244,7 @@ func TestRoomServer_StartMessage(t *testing.T) {
 		Msg: game.BeginMsg{
 			NextWord:        word,
 			NextPlayerIndex: 0,
+			Deadline:        1700000000000 + int64(settings.TimeLimitSecs)*1000,
 		},
 	}
 
@@ -199,3 +270,191 @@ func TestRoomsServer_DrawMessage(t *testing.T) {
 
 	runTestMessage(t, ws, input, expOutput)
 }
+
+func TestRoomsServer_BinaryDrawMessage(t *testing.T) {
+	initialState := game.NewGameState("123abc", MockSettings("Word"))
+	initialState.StartGame()
+
+	s, ws, _ := beforeTestJoinRoomWithQuery(t, initialState, "&version=2&features=binary")
+	defer s.Close()
+	defer ws.Close()
+
+	// packed layout: code, color, radius, x (little endian), y (little endian), connected
+	input := []byte{game.DrawCode, 1, 2, 34, 0, 47, 0, 1}
+	if err := ws.WriteMessage(websocket.BinaryMessage, input); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	messageType, bufOut, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if messageType != websocket.BinaryMessage {
+		t.Fatalf("Expected a binary frame for a subscriber that negotiated binary")
+	}
+	if !reflect.DeepEqual(bufOut, input) {
+		t.Fatalf("Output %v didn't match expected value %v", bufOut, input)
+	}
+}
+
+func TestRoomsServer_StrokeMessage(t *testing.T) {
+	initialState := game.NewGameState("123abc", MockSettings("Word"))
+	initialState.StartGame()
+
+	s, ws, _ := beforeTestJoinRoomWithQuery(t, initialState, "&version=2&features=batch")
+	defer s.Close()
+	defer ws.Close()
+
+	input := game.InputPayload[game.StrokeMsg]{
+		Code: game.StrokeCode,
+		Msg:  game.StrokeMsg{Color: 1, Points: []game.Point{{X: 34, Y: 47}, {X: 35, Y: 49}}},
+	}
+	expOutput := game.OutputPayload[game.DrawBatchMsg]{
+		Code: game.DrawBatchCode,
+		Msg: game.DrawBatchMsg{
+			{Color: 1, X: 34, Y: 47, Connected: false},
+			{Color: 1, X: 35, Y: 49, Connected: true},
+		},
+	}
+
+	runTestMessage(t, ws, input, expOutput)
+}
+
+func TestRoomsServer_CoalescedStrokeMessage(t *testing.T) {
+	settings := MockSettings("Word")
+	settings.CoalesceMs = 10
+	initialState := game.NewGameState("123abc", settings)
+	initialState.StartGame()
+
+	s, ws, _ := beforeTestJoinRoomWithQuery(t, initialState, "&version=2&features=batch")
+	defer s.Close()
+	defer ws.Close()
+
+	// both draws should arrive in a single batch once the coalescing window elapses
+	inputs := []game.InputPayload[game.DrawMsg]{
+		{Code: game.DrawCode, Msg: game.DrawMsg{X: 34, Y: 47}},
+		{Code: game.DrawCode, Msg: game.DrawMsg{X: 35, Y: 49, Connected: true}},
+	}
+	for _, input := range inputs {
+		bufIn, _ := json.Marshal(input)
+		if err := ws.WriteMessage(websocket.TextMessage, bufIn); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	var circles game.DrawBatchMsg
+	for len(circles) < len(inputs) {
+		_, bufOut, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var payload game.OutputPayload[game.DrawBatchMsg]
+		if err = json.Unmarshal(bufOut, &payload); err != nil || payload.Code != game.DrawBatchCode {
+			t.Fatalf("Expected only draw batches to be sent to a batching subscriber, got %s", string(bufOut))
+		}
+		circles = append(circles, payload.Msg...)
+	}
+
+	expCircles := game.DrawBatchMsg{inputs[0].Msg, inputs[1].Msg}
+	if !reflect.DeepEqual(circles, expCircles) {
+		t.Fatalf("Output %+v didn't match expected value %+v", circles, expCircles)
+	}
+}
+
+func TestRoomsServer_Handshake(t *testing.T) {
+	initialState := game.NewGameState("123abc", MockSettings("Word"))
+	testRoom := game.NewRoom(initialState, true, &FakeHandler{})
+	go testRoom.Start()
+	mockRooms := StubBrokerage{}
+	mockRooms.Set(initialState.Code(), testRoom)
+
+	roomsServer := NewRoomsServer(&mockRooms, &StubAuthenticator{testPlayer: GuestUser()}, &FakeHandler{}, []string{})
+	s := httptest.NewServer(http.HandlerFunc(roomsServer.JoinRoom))
+	defer s.Close()
+
+	u := "ws" + strings.TrimPrefix(s.URL, "http") + "?code=" + initialState.Code()
+
+	// a versioned client is told which of its announced features were accepted, unknown features are dropped
+	ws, _, err := websocket.DefaultDialer.Dial(u+"&version=2&features=binary,compress,unknown", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ws.Close()
+
+	_, buf, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var payload game.OutputPayload[game.ProtocolMsg]
+	if err = json.Unmarshal(buf, &payload); err != nil {
+		t.Fatalf("%v", err)
+	}
+	expMsg := game.ProtocolMsg{Version: 2, Features: []string{game.BinaryFeature, game.CompressionFeature}}
+	if payload.Code != game.ProtocolCode || !reflect.DeepEqual(payload.Msg, expMsg) {
+		t.Fatalf("Output %+v didn't match expected value %+v", payload.Msg, expMsg)
+	}
+
+	// a client from a newer protocol version is rejected with an error then a close frame
+	rejected, _, err := websocket.DefaultDialer.Dial(u+"&version=99", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer rejected.Close()
+
+	_, buf, err = rejected.ReadMessage()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var errResp ErrorResp
+	if err = json.Unmarshal(buf, &errResp); err != nil || errResp.Code != game.ErrorCode {
+		t.Fatalf("Expected an error message for an incompatible client, got %s", string(buf))
+	}
+	_, _, err = rejected.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseProtocolError) {
+		t.Fatalf("Expected the socket to be closed with a protocol error, got %v", err)
+	}
+}
+
+func TestRoomsServer_TruncateReason(t *testing.T) {
+	if reason := truncateReason("short"); reason != "short" {
+		t.Fatalf("Expected a short reason to be kept, got %s", reason)
+	}
+	// each character after the first is 3 bytes, so the limit falls in the middle of one
+	long := "a" + strings.Repeat("語", 50)
+	reason := truncateReason(long)
+	if len(reason) != 121 || !utf8.ValidString(reason) {
+		t.Fatalf("Expected the reason to be cut before the character crossing the limit, got %d bytes", len(reason))
+	}
+}
+
+func TestRoomsServer_SubscriberOverflow(t *testing.T) {
+	roomsServer := NewRoomsServer(&StubBrokerage{}, &StubAuthenticator{}, &FakeHandler{}, []string{})
+
+	subscriber := make(chan pubsub.Message, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := roomsServer.upgrade.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		go roomsServer.subscriberListener(ws, subscriber)
+	}))
+	defer s.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ws.Close()
+
+	// a subscriber cut off by the pubsub has its socket closed so the client reconnects
+	subscriber <- pubsub.Message{Data: []byte("hello")}
+	subscriber <- pubsub.Message{Overflowed: true}
+	if _, buf, err := ws.ReadMessage(); err != nil || string(buf) != "hello" {
+		t.Fatalf("Expected the messages before the overflow to be sent, got %s and %v", string(buf), err)
+	}
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Fatalf("Expected the socket to be closed for the client to reconnect, got %v", err)
+	}
+	close(subscriber)
+}